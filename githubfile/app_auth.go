@@ -0,0 +1,327 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v54/github"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// appJWTLifetime is how long the JWTs used to authenticate as the app
+	// itself are valid for. GitHub rejects anything over ten minutes.
+	appJWTLifetime = 9 * time.Minute
+	// installationTokenExpiryDelta is how long before their expiry that
+	// installation tokens are refreshed.
+	installationTokenExpiryDelta = 5 * time.Minute
+	// installationTokenTimeout bounds how long minting an installation token
+	// may take, whatever the deadline of the request it is minted for.
+	installationTokenTimeout = 30 * time.Second
+)
+
+// newAppAuthTransport returns a transport that authenticates requests as an
+// installation of the configured GitHub App.
+func newAppAuthTransport(m *appAuthModel, ep *endpoint, base http.RoundTripper) (*appInstallationTransport, error) {
+	id := stringValueOrEnv(m.ID, "GITHUB_APP_ID")
+	if id == "" {
+		return nil, errors.New("app_auth.id must be configured or the GITHUB_APP_ID environment variable must be set")
+	}
+	f := stringValueOrEnv(m.PemFile, "GITHUB_APP_PEM_FILE")
+	if f == "" {
+		return nil, errors.New("app_auth.pem_file must be configured or the GITHUB_APP_PEM_FILE environment variable must be set")
+	}
+	v, err := os.ReadFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %v", f, err)
+	}
+	k, err := parseAppPrivateKey(v)
+	if err != nil {
+		return nil, err
+	}
+	var iid int64
+	if v := stringValueOrEnv(m.InstallationID, "GITHUB_APP_INSTALLATION_ID"); v != "" {
+		iid, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %q as an installation id: %v", v, err)
+		}
+	}
+	apps, err := ep.newClient(&http.Client{Transport: &jwtTransport{appID: id, key: k, base: base}})
+	if err != nil {
+		return nil, err
+	}
+	return newAppInstallationTransport(apps, iid, base), nil
+}
+
+// jwtTransport authenticates requests as a GitHub App using short-lived JWTs
+// signed with the app's private key.
+type jwtTransport struct {
+	appID string
+	key   *rsa.PrivateKey
+	base  http.RoundTripper
+}
+
+func (t *jwtTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	s, err := signAppJWT(t.appID, t.key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", "Bearer "+s)
+	return t.base.RoundTrip(r)
+}
+
+// installationTokenSource mints installation access tokens for a single
+// installation of a GitHub App, reusing each until it is about to expire.
+type installationTokenSource struct {
+	apps           *github.Client
+	installationID int64
+
+	// mintMu is held while minting a token, so that requests needing a new
+	// token wait for a single one to be minted, while mu only guards tok so
+	// that requests holding a valid token never wait for GitHub.
+	mintMu sync.Mutex
+	mu     sync.RWMutex
+	tok    *oauth2.Token
+}
+
+// current returns the current token if it is still valid, and nil otherwise.
+func (s *installationTokenSource) current() *oauth2.Token {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.tok.Valid() {
+		return s.tok
+	}
+	return nil
+}
+
+// token returns a valid installation token, minting one within the given
+// context if needed.
+func (s *installationTokenSource) token(ctx context.Context) (*oauth2.Token, error) {
+	if tok := s.current(); tok != nil {
+		return tok, nil
+	}
+	s.mintMu.Lock()
+	defer s.mintMu.Unlock()
+	// Another request may have minted one while this one waited.
+	if tok := s.current(); tok != nil {
+		return tok, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, installationTokenTimeout)
+	defer cancel()
+	t, _, err := s.apps.Apps.CreateInstallationToken(ctx, s.installationID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create installation token for installation %d: %v", s.installationID, err)
+	}
+	tok := &oauth2.Token{
+		AccessToken: t.GetToken(),
+		Expiry:      t.GetExpiresAt().Add(-installationTokenExpiryDelta),
+	}
+	s.mu.Lock()
+	s.tok = tok
+	s.mu.Unlock()
+	return tok, nil
+}
+
+// appInstallationTransport authenticates requests with installation tokens
+// of a GitHub App. If no installation ID is given, the installation is looked
+// up from the owner targeted by each request, and cached.
+type appInstallationTransport struct {
+	apps           *github.Client
+	installationID int64
+	base           http.RoundTripper
+
+	mu      sync.Mutex
+	sources map[string]*installationSource
+}
+
+// installationSource holds the token source of the app installation on an
+// owner once it has been looked up. Its lock is only held while looking up
+// that owner's installation, so that looking up one owner does not hold up
+// requests to others.
+type installationSource struct {
+	mu sync.Mutex
+	ts *installationTokenSource
+}
+
+func newAppInstallationTransport(apps *github.Client, installationID int64, base http.RoundTripper) *appInstallationTransport {
+	return &appInstallationTransport{
+		apps:           apps,
+		installationID: installationID,
+		base:           base,
+		sources:        make(map[string]*installationSource),
+	}
+}
+
+// requestOwnerKey is the context key of the owner whose app installation
+// authenticates the requests made with a context.
+type requestOwnerKey struct{}
+
+// withRequestOwner returns a copy of ctx whose requests are authenticated
+// with the app installation on the given owner, for requests that are not
+// scoped to an owner, such as looking up the app's bot user.
+func withRequestOwner(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, requestOwnerKey{}, owner)
+}
+
+func (t *appInstallationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	owner := ""
+	if t.installationID == 0 {
+		owner, _ = req.Context().Value(requestOwnerKey{}).(string)
+		if owner == "" {
+			owner = requestOwner(t.apps.BaseURL.Path, req.URL.Path)
+		}
+		if owner == "" {
+			return nil, fmt.Errorf("cannot determine the repository owner of %s %s in order to look up the app installation; set app_auth.installation_id", req.Method, req.URL.Path)
+		}
+	}
+	ts, err := t.tokenSource(req.Context(), owner)
+	if err != nil {
+		return nil, err
+	}
+	tok, err := ts.token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	r := req.Clone(req.Context())
+	tok.SetAuthHeader(r)
+	return t.base.RoundTrip(r)
+}
+
+func (t *appInstallationTransport) tokenSource(ctx context.Context, owner string) (*installationTokenSource, error) {
+	t.mu.Lock()
+	e, ok := t.sources[owner]
+	if !ok {
+		e = &installationSource{}
+		t.sources[owner] = e
+	}
+	t.mu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.ts != nil {
+		return e.ts, nil
+	}
+	id := t.installationID
+	if id == 0 {
+		// A failed lookup is not cached, so that the next request tries
+		// again.
+		v, err := findAppInstallation(ctx, t.apps, owner)
+		if err != nil {
+			return nil, err
+		}
+		id = v
+	}
+	e.ts = &installationTokenSource{apps: t.apps, installationID: id}
+	return e.ts, nil
+}
+
+// findAppInstallation returns the ID of the app installation on the given
+// organisation or user.
+func findAppInstallation(ctx context.Context, apps *github.Client, owner string) (int64, error) {
+	i, res, err := apps.Apps.FindOrganizationInstallation(ctx, owner)
+	if err == nil {
+		return i.GetID(), nil
+	}
+	if res == nil || res.StatusCode != http.StatusNotFound {
+		return 0, fmt.Errorf("failed to find app installation for %q: %v", owner, err)
+	}
+	i, _, err = apps.Apps.FindUserInstallation(ctx, owner)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find app installation for %q: %v", owner, err)
+	}
+	return i.GetID(), nil
+}
+
+// requestOwner returns the owner targeted by a request to the given API path,
+// or an empty string if the path is not scoped to an owner. Bot users, which
+// apps cannot be installed on, are not owners.
+func requestOwner(basePath, path string) string {
+	p := strings.Split(strings.TrimPrefix(path, basePath), "/")
+	if len(p) < 2 {
+		return ""
+	}
+	switch p[0] {
+	case "repos", "orgs":
+		return p[1]
+	case "users":
+		if !strings.HasSuffix(p[1], "[bot]") {
+			return p[1]
+		}
+	}
+	return ""
+}
+
+// signAppJWT returns a JWT identifying the given app, signed with its key.
+func signAppJWT(appID string, key *rsa.PrivateKey, now time.Time) (string, error) {
+	h, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(map[string]interface{}{
+		// Backdate the token slightly to allow for clock drift.
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(appJWTLifetime).Unix(),
+		"iss": appID,
+	})
+	if err != nil {
+		return "", err
+	}
+	s := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	d := sha256.Sum256([]byte(s))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, d[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign app JWT: %v", err)
+	}
+	return s + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// parseAppPrivateKey parses a PEM-encoded RSA private key in either PKCS #1
+// (as downloaded from GitHub) or PKCS #8 form.
+func parseAppPrivateKey(v []byte) (*rsa.PrivateKey, error) {
+	b, _ := pem.Decode(v)
+	if b == nil {
+		return nil, errors.New("failed to decode PEM block containing the app's private key")
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(b.Bytes); err == nil {
+		return k, nil
+	}
+	k, err := x509.ParsePKCS8PrivateKey(b.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the app's private key: %v", err)
+	}
+	r, ok := k.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("expected an RSA private key, got %T", k)
+	}
+	return r, nil
+}
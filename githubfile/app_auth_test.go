@@ -0,0 +1,192 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v54/github"
+)
+
+func TestAppInstallationTransport(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	// requireAppJWT fails the test unless the request carries a JWT signed
+	// with the app's key.
+	requireAppJWT := func(r *http.Request) {
+		s := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		p := strings.Split(s, ".")
+		if len(p) != 3 {
+			t.Errorf("expected a JWT, got %q", s)
+			return
+		}
+		sig, _ := base64.RawURLEncoding.DecodeString(p[2])
+		d := sha256.Sum256([]byte(p[0] + "." + p[1]))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, d[:], sig); err != nil {
+			t.Errorf("invalid JWT signature: %v", err)
+		}
+	}
+
+	var tokens int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/orgs/test-org/installation", func(w http.ResponseWriter, r *http.Request) {
+		requireAppJWT(r)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":7}`)
+	})
+	mux.HandleFunc("/app/installations/7/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		requireAppJWT(r)
+		atomic.AddInt32(&tokens, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"token":"installation-token","expires_at":%q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+	})
+	mux.HandleFunc("/repos/test-org/test-repo", func(w http.ResponseWriter, r *http.Request) {
+		if v := r.Header.Get("Authorization"); v != "Bearer installation-token" {
+			t.Errorf("expected installation token, got %q", v)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"name":"test-repo"}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	newClient := func(rt http.RoundTripper) *github.Client {
+		c := github.NewClient(&http.Client{Transport: rt})
+		c.BaseURL, _ = url.Parse(server.URL + "/")
+		return c
+	}
+	apps := newClient(&jwtTransport{appID: "1", key: key, base: http.DefaultTransport})
+	client := newClient(newAppInstallationTransport(apps, 0, http.DefaultTransport))
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := client.Repositories.Get(context.Background(), "test-org", "test-repo"); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+	if v := atomic.LoadInt32(&tokens); v != 1 {
+		t.Fatalf("expected the installation token to be minted once, got %d", v)
+	}
+}
+
+func TestInstallationTokenSource_Cancelled(t *testing.T) {
+	var tokens int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/app/installations/7/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tokens, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"token":"installation-token","expires_at":%q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	s := &installationTokenSource{apps: newMockGitHubClient(server), installationID: 7}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.token(ctx); err == nil {
+		t.Fatal("expected minting a token with a cancelled context to fail")
+	}
+	if v := atomic.LoadInt32(&tokens); v != 0 {
+		t.Fatalf("expected no token to be minted, got %d", v)
+	}
+
+	tok, err := s.token(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if tok.AccessToken != "installation-token" {
+		t.Fatalf("expected the minted token, got %q", tok.AccessToken)
+	}
+}
+
+func TestAppInstallationTransport_SlowLookup(t *testing.T) {
+	// The lookup of slow-org's installation stalls until the test ends.
+	stalled := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/orgs/slow-org/installation", func(w http.ResponseWriter, r *http.Request) {
+		<-stalled
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc("/orgs/test-org/installation", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":7}`)
+	})
+	mux.HandleFunc("/app/installations/7/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"token":"installation-token","expires_at":%q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+	})
+	mux.HandleFunc("/repos/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"name":"test-repo"}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	defer close(stalled)
+
+	apps := newMockGitHubClient(server)
+	client := github.NewClient(&http.Client{Transport: newAppInstallationTransport(apps, 0, http.DefaultTransport)})
+	client.BaseURL = apps.BaseURL
+
+	go client.Repositories.Get(context.Background(), "slow-org", "test-repo") //nolint:errcheck
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := client.Repositories.Get(context.Background(), "test-org", "test-repo")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the request to test-org not to wait for the lookup of slow-org's installation")
+	}
+}
+
+func TestRequestOwner(t *testing.T) {
+	tests := map[string]string{
+		"/repos/test-org/test-repo/contents/README.md": "test-org",
+		"/api/v3/orgs/test-org/repos":                  "test-org",
+		"/users/test-user":                             "test-user",
+		"/users/file-bot[bot]":                         "",
+		"/app/installations/7/access_tokens":           "",
+	}
+	for p, expected := range tests {
+		b := "/"
+		if strings.HasPrefix(p, "/api/v3/") {
+			b = "/api/v3/"
+		}
+		if v := requestOwner(b, p); v != expected {
+			t.Errorf("requestOwner(%q, %q) = %q, expected %q", b, p, v, expected)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/google/go-github/v54/github"
+	"golang.org/x/oauth2"
+)
+
+// endpoint describes the GitHub API the provider talks to.
+type endpoint struct {
+	baseURL   string
+	uploadURL string
+}
+
+// newClient returns a GitHub client using the given HTTP client, pointed at
+// the endpoint. An empty base URL means github.com.
+func (e *endpoint) newClient(hc *http.Client) (*github.Client, error) {
+	if e.baseURL == "" {
+		return github.NewClient(hc), nil
+	}
+	u := e.uploadURL
+	if u == "" {
+		b, err := url.Parse(e.baseURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %q as a base url: %v", e.baseURL, err)
+		}
+		u = fmt.Sprintf("%s://%s/api/uploads/", b.Scheme, b.Host)
+	}
+	c, err := github.NewEnterpriseClient(e.baseURL, u, hc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client for %q: %v", e.baseURL, err)
+	}
+	return c, nil
+}
+
+// noreplyDomain returns the domain of the noreply email addresses GitHub
+// assigns to users of the endpoint.
+func (e *endpoint) noreplyDomain() string {
+	if e.baseURL == "" {
+		return "users.noreply.github.com"
+	}
+	u, err := url.Parse(e.baseURL)
+	if err != nil {
+		return "users.noreply.github.com"
+	}
+	return "users.noreply." + u.Hostname()
+}
+
+// newAuthenticatedClient returns a GitHub client pointed at the endpoint that
+// authenticates as an installation of the given app or, if none is given,
+// with the given token. Requests are unauthenticated if neither is given.
+// When authenticating as an app, a client authenticating as the app itself
+// is returned too.
+func newAuthenticatedClient(token string, appAuth *appAuthModel, ep *endpoint, base http.RoundTripper) (*github.Client, *github.Client, error) {
+	var apps *github.Client
+	rt := base
+	switch {
+	case appAuth != nil:
+		t, err := newAppAuthTransport(appAuth, ep, base)
+		if err != nil {
+			return nil, nil, err
+		}
+		apps = t.apps
+		rt = t
+	case token != "":
+		rt = &oauth2.Transport{
+			Source: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}),
+			Base:   base,
+		}
+	}
+	c, err := ep.newClient(&http.Client{Transport: rt})
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, apps, nil
+}
+
+// newBaseTransport returns the transport underlying every request to GitHub,
+// trusting the certificates in the given CA bundle in addition to the system
+// ones and sending requests through the given proxy. If no proxy is given,
+// the usual proxy environment variables are honoured.
+func newBaseTransport(caBundleFile, proxyURL string) (http.RoundTripper, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if caBundleFile != "" {
+		v, err := os.ReadFile(caBundleFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %q: %v", caBundleFile, err)
+		}
+		p, err := x509.SystemCertPool()
+		if err != nil {
+			p = x509.NewCertPool()
+		}
+		if !p.AppendCertsFromPEM(v) {
+			return nil, fmt.Errorf("no certificates found in %q", caBundleFile)
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: p, MinVersion: tls.VersionTLS12}
+	}
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %q as a proxy url: %v", proxyURL, err)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	return t, nil
+}
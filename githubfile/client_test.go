@@ -0,0 +1,79 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEndpointNewClient(t *testing.T) {
+	tests := []struct {
+		endpoint  endpoint
+		baseURL   string
+		uploadURL string
+	}{
+		{endpoint{}, "https://api.github.com/", "https://uploads.github.com/"},
+		{endpoint{baseURL: "https://github.example.com"}, "https://github.example.com/api/v3/", "https://github.example.com/api/uploads/"},
+		{endpoint{baseURL: "https://github.example.com/api/v3/", uploadURL: "https://uploads.example.com/"}, "https://github.example.com/api/v3/", "https://uploads.example.com/api/uploads/"},
+	}
+	for _, tt := range tests {
+		c, err := tt.endpoint.newClient(http.DefaultClient)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if v := c.BaseURL.String(); v != tt.baseURL {
+			t.Errorf("expected base url %q, got %q", tt.baseURL, v)
+		}
+		if v := c.UploadURL.String(); v != tt.uploadURL {
+			t.Errorf("expected upload url %q, got %q", tt.uploadURL, v)
+		}
+	}
+}
+
+func TestNewBaseTransport_CABundle(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/repos/test-owner/test-repo", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":1,"name":"test-repo"}`)
+	})
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+
+	f := filepath.Join(t.TempDir(), "ca.pem")
+	v := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(f, v, 0600); err != nil {
+		t.Fatalf("failed to write CA bundle: %v", err)
+	}
+
+	bt, err := newBaseTransport(f, "")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	ep := &endpoint{baseURL: server.URL}
+	c, err := ep.newClient(&http.Client{Transport: bt})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, _, err := c.Repositories.Get(context.Background(), "test-owner", "test-repo"); err != nil {
+		t.Fatalf("expected the custom CA to be trusted, got: %v", err)
+	}
+}
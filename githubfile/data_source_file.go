@@ -0,0 +1,259 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v54/github"
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+var (
+	_ datasource.DataSource              = &fileDataSource{}
+	_ datasource.DataSourceWithConfigure = &fileDataSource{}
+)
+
+type fileDataSource struct {
+	config *providerConfiguration
+}
+
+type fileDataSourceModel struct {
+	ID              types.String `tfsdk:"id"`
+	RepositoryOwner types.String `tfsdk:"repository_owner"`
+	RepositoryName  types.String `tfsdk:"repository_name"`
+	Ref             types.String `tfsdk:"ref"`
+	Path            types.String `tfsdk:"path"`
+	Contents        types.String `tfsdk:"contents"`
+	ContentsBase64  types.String `tfsdk:"contents_base64"`
+	BlobSHA         types.String `tfsdk:"blob_sha"`
+	Size            types.Int64  `tfsdk:"size"`
+	Mode            types.String `tfsdk:"mode"`
+	CommitSHA       types.String `tfsdk:"commit_sha"`
+}
+
+// NewFileDataSource returns a new file data source.
+func NewFileDataSource() datasource.DataSource {
+	return &fileDataSource{}
+}
+
+func (d *fileDataSource) Metadata(_ context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_file"
+}
+
+func (d *fileDataSource) Schema(_ context.Context, _ datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				Computed:    true,
+				Description: "The ID of the file (format: owner/repo:ref:path).",
+			},
+			"repository_owner": schema.StringAttribute{
+				Required:    true,
+				Description: "The owner of the repository from which to read the file.",
+			},
+			"repository_name": schema.StringAttribute{
+				Required:    true,
+				Description: "The name of the repository from which to read the file.",
+			},
+			"ref": schema.StringAttribute{
+				Optional:    true,
+				Computed:    true,
+				Description: "The branch, tag or commit SHA from which to read the file. Defaults to the repository's default branch.",
+			},
+			"path": schema.StringAttribute{
+				Required:    true,
+				Description: "The path of the file to read.",
+			},
+			"contents": schema.StringAttribute{
+				Computed:    true,
+				Description: "The contents of the file.",
+			},
+			"contents_base64": schema.StringAttribute{
+				Computed:    true,
+				Description: "The base64-encoded contents of the file.",
+			},
+			"blob_sha": schema.StringAttribute{
+				Computed:    true,
+				Description: "The SHA of the blob holding the file's contents.",
+			},
+			"size": schema.Int64Attribute{
+				Computed:    true,
+				Description: "The size of the file in bytes.",
+			},
+			"mode": schema.StringAttribute{
+				Computed:    true,
+				Description: "The git file mode of the file (e.g. 100644).",
+			},
+			"commit_sha": schema.StringAttribute{
+				Computed:    true,
+				Description: "The SHA of the last commit on the ref that touched the file.",
+			},
+		},
+	}
+}
+
+func (d *fileDataSource) Configure(_ context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+	config, ok := req.ProviderData.(*providerConfiguration)
+	if !ok {
+		resp.Diagnostics.AddError(
+			"Unexpected Provider Data",
+			fmt.Sprintf("Expected *providerConfiguration, got: %T", req.ProviderData),
+		)
+		return
+	}
+	d.config = config
+}
+
+func (d *fileDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	var config fileDataSourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &config)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	f, err := readRemoteFile(ctx, d.config,
+		config.RepositoryOwner.ValueString(),
+		config.RepositoryName.ValueString(),
+		config.Ref.ValueString(),
+		config.Path.ValueString())
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to read file", err.Error())
+		return
+	}
+
+	config.ID = types.StringValue(fmt.Sprintf("%s/%s:%s:%s", f.repositoryOwner, f.repositoryName, f.ref, f.path))
+	config.Ref = types.StringValue(f.ref)
+	config.Contents = types.StringValue(f.contents)
+	config.ContentsBase64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte(f.contents)))
+	config.BlobSHA = types.StringValue(f.blobSHA)
+	config.Size = types.Int64Value(int64(f.size))
+	config.Mode = types.StringValue(f.mode)
+	config.CommitSHA = types.StringValue(f.commitSHA)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &config)...)
+}
+
+// readRemoteFile reads the file at the given path and ref, along with its
+// blob and commit metadata. An empty ref means the default branch.
+func readRemoteFile(ctx context.Context, c *providerConfiguration, owner, repo, ref, path string) (*remoteFile, error) {
+	gc := c.client(owner)
+	if ref == "" {
+		r, _, err := gc.Repositories.Get(ctx, owner, repo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve repository %s/%s: %v", owner, repo, err)
+		}
+		ref = r.GetDefaultBranch()
+	}
+
+	fc, dc, res, err := gc.Repositories.GetContents(ctx, owner, repo, path, &github.RepositoryContentGetOptions{
+		Ref: ref,
+	})
+	if err != nil {
+		if res != nil && res.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%q does not exist at ref %q of repository %s/%s", path, ref, owner, repo)
+		}
+		return nil, fmt.Errorf("failed to read %q: %v", path, err)
+	}
+	if fc == nil || dc != nil {
+		return nil, fmt.Errorf("%q at ref %q of repository %s/%s is a directory, not a file", path, ref, owner, repo)
+	}
+	if fc.GetType() != "file" {
+		return nil, fmt.Errorf("%q at ref %q of repository %s/%s is a %s, not a file", path, ref, owner, repo, fc.GetType())
+	}
+
+	f := &remoteFile{
+		repositoryOwner: owner,
+		repositoryName:  repo,
+		ref:             ref,
+		path:            path,
+		blobSHA:         fc.GetSHA(),
+		size:            fc.GetSize(),
+	}
+
+	// Files larger than 1MB are returned without contents, in which case
+	// they must be fetched through the blobs API.
+	if fc.GetEncoding() == "none" {
+		b, _, err := gc.Git.GetBlobRaw(ctx, owner, repo, f.blobSHA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read blob %s: %v", f.blobSHA, err)
+		}
+		f.contents = string(b)
+	} else {
+		v, err := fc.GetContent()
+		if err != nil {
+			return nil, err
+		}
+		f.contents = v
+	}
+
+	commits, _, err := gc.Repositories.ListCommits(ctx, owner, repo, &github.CommitsListOptions{
+		SHA:         ref,
+		Path:        path,
+		ListOptions: github.ListOptions{PerPage: 1},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits for %q: %v", path, err)
+	}
+	if len(commits) == 0 {
+		return nil, fmt.Errorf("no commits found for %q at ref %q", path, ref)
+	}
+	f.commitSHA = commits[0].GetSHA()
+
+	// The last commit that touched the path holds the same version of the
+	// file as the ref, so its tree can be used to look up the file mode.
+	e, err := getTreeEntry(ctx, gc, owner, repo, commits[0].GetCommit().GetTree().GetSHA(), path)
+	if err != nil {
+		return nil, err
+	}
+	f.mode = e.GetMode()
+	return f, nil
+}
+
+// getTreeEntry walks the tree with the given SHA one directory at a time
+// and returns the entry for the given path.
+func getTreeEntry(ctx context.Context, c *github.Client, owner, repo, treeSHA, path string) (*github.TreeEntry, error) {
+	p := strings.Split(strings.Trim(path, "/"), "/")
+	s := treeSHA
+	for i, name := range p {
+		t, _, err := c.Git.GetTree(ctx, owner, repo, s, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve tree %s: %v", s, err)
+		}
+		var e *github.TreeEntry
+		for _, v := range t.Entries {
+			if v.GetPath() == name {
+				e = v
+				break
+			}
+		}
+		if e == nil {
+			return nil, fmt.Errorf("%q not found in tree %s", strings.Join(p[:i+1], "/"), treeSHA)
+		}
+		if i == len(p)-1 {
+			return e, nil
+		}
+		s = e.GetSHA()
+	}
+	return nil, fmt.Errorf("%q not found in tree %s", path, treeSHA)
+}
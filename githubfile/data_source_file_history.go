@@ -0,0 +1,253 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/form3tech-oss/go-github-utils/pkg/branch"
+	"github.com/google/go-github/v54/github"
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+const defaultFileHistoryLimit = 100
+
+var (
+	_ datasource.DataSource              = &fileHistoryDataSource{}
+	_ datasource.DataSourceWithConfigure = &fileHistoryDataSource{}
+)
+
+type fileHistoryDataSource struct {
+	config *providerConfiguration
+}
+
+type fileHistoryDataSourceModel struct {
+	ID              types.String      `tfsdk:"id"`
+	RepositoryOwner types.String      `tfsdk:"repository_owner"`
+	RepositoryName  types.String      `tfsdk:"repository_name"`
+	Ref             types.String      `tfsdk:"ref"`
+	Path            types.String      `tfsdk:"path"`
+	Limit           types.Int64       `tfsdk:"limit"`
+	Commits         []fileCommitModel `tfsdk:"commits"`
+}
+
+type fileCommitModel struct {
+	SHA                types.String `tfsdk:"sha"`
+	AuthorName         types.String `tfsdk:"author_name"`
+	AuthorEmail        types.String `tfsdk:"author_email"`
+	AuthorLogin        types.String `tfsdk:"author_login"`
+	CommitterName      types.String `tfsdk:"committer_name"`
+	CommitterEmail     types.String `tfsdk:"committer_email"`
+	CommitterLogin     types.String `tfsdk:"committer_login"`
+	Date               types.String `tfsdk:"date"`
+	Message            types.String `tfsdk:"message"`
+	Verified           types.Bool   `tfsdk:"verified"`
+	VerificationReason types.String `tfsdk:"verification_reason"`
+}
+
+// NewFileHistoryDataSource returns a new file history data source.
+func NewFileHistoryDataSource() datasource.DataSource {
+	return &fileHistoryDataSource{}
+}
+
+func (d *fileHistoryDataSource) Metadata(_ context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_file_history"
+}
+
+func (d *fileHistoryDataSource) Schema(_ context.Context, _ datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				Computed:    true,
+				Description: "The ID of the file (format: owner/repo:ref:path).",
+			},
+			"repository_owner": schema.StringAttribute{
+				Required:    true,
+				Description: "The owner of the repository containing the file.",
+			},
+			"repository_name": schema.StringAttribute{
+				Required:    true,
+				Description: "The name of the repository containing the file.",
+			},
+			"ref": schema.StringAttribute{
+				Optional:    true,
+				Computed:    true,
+				Description: "The branch, tag or commit SHA from which to walk the history. Defaults to the repository's default branch.",
+			},
+			"path": schema.StringAttribute{
+				Required:    true,
+				Description: "The path of the file whose history to list.",
+			},
+			"limit": schema.Int64Attribute{
+				Optional:    true,
+				Description: fmt.Sprintf("The maximum number of commits to return. Defaults to %d.", defaultFileHistoryLimit),
+			},
+			"commits": schema.ListNestedAttribute{
+				Computed:    true,
+				Description: "The commits that touched the file, most recent first.",
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"sha": schema.StringAttribute{
+							Computed:    true,
+							Description: "The SHA of the commit.",
+						},
+						"author_name": schema.StringAttribute{
+							Computed:    true,
+							Description: "The name of the commit's author.",
+						},
+						"author_email": schema.StringAttribute{
+							Computed:    true,
+							Description: "The email address of the commit's author.",
+						},
+						"author_login": schema.StringAttribute{
+							Computed:    true,
+							Description: "The GitHub login of the commit's author, if the email address is linked to an account.",
+						},
+						"committer_name": schema.StringAttribute{
+							Computed:    true,
+							Description: "The name of the commit's committer.",
+						},
+						"committer_email": schema.StringAttribute{
+							Computed:    true,
+							Description: "The email address of the commit's committer.",
+						},
+						"committer_login": schema.StringAttribute{
+							Computed:    true,
+							Description: "The GitHub login of the commit's committer, if the email address is linked to an account.",
+						},
+						"date": schema.StringAttribute{
+							Computed:    true,
+							Description: "The date on which the commit was made (RFC 3339).",
+						},
+						"message": schema.StringAttribute{
+							Computed:    true,
+							Description: "The commit message.",
+						},
+						"verified": schema.BoolAttribute{
+							Computed:    true,
+							Description: "Whether GitHub verified the commit's signature.",
+						},
+						"verification_reason": schema.StringAttribute{
+							Computed:    true,
+							Description: "The reason given by GitHub for the commit's verification status.",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (d *fileHistoryDataSource) Configure(_ context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+	config, ok := req.ProviderData.(*providerConfiguration)
+	if !ok {
+		resp.Diagnostics.AddError(
+			"Unexpected Provider Data",
+			fmt.Sprintf("Expected *providerConfiguration, got: %T", req.ProviderData),
+		)
+		return
+	}
+	d.config = config
+}
+
+func (d *fileHistoryDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	var config fileHistoryDataSourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &config)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	limit := defaultFileHistoryLimit
+	if !config.Limit.IsNull() {
+		limit = int(config.Limit.ValueInt64())
+		if limit <= 0 {
+			resp.Diagnostics.AddError("Invalid limit", fmt.Sprintf("limit must be greater than zero, got %d.", limit))
+			return
+		}
+	}
+
+	owner := config.RepositoryOwner.ValueString()
+	repo := config.RepositoryName.ValueString()
+	path := config.Path.ValueString()
+	ref := config.Ref.ValueString()
+	if ref == "" {
+		v, err := branch.GetDefaultBranch(ctx, d.config.client(owner), owner, repo)
+		if err != nil {
+			resp.Diagnostics.AddError("Failed to retrieve default branch", err.Error())
+			return
+		}
+		ref = v
+	}
+
+	commits, err := listFileCommits(ctx, d.config.client(owner), owner, repo, ref, path, limit)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to list file history", err.Error())
+		return
+	}
+
+	config.Commits = []fileCommitModel{}
+	for _, c := range commits {
+		v := c.GetCommit().GetVerification()
+		config.Commits = append(config.Commits, fileCommitModel{
+			SHA:                types.StringValue(c.GetSHA()),
+			AuthorName:         types.StringValue(c.GetCommit().GetAuthor().GetName()),
+			AuthorEmail:        types.StringValue(c.GetCommit().GetAuthor().GetEmail()),
+			AuthorLogin:        types.StringValue(c.GetAuthor().GetLogin()),
+			CommitterName:      types.StringValue(c.GetCommit().GetCommitter().GetName()),
+			CommitterEmail:     types.StringValue(c.GetCommit().GetCommitter().GetEmail()),
+			CommitterLogin:     types.StringValue(c.GetCommitter().GetLogin()),
+			Date:               types.StringValue(c.GetCommit().GetCommitter().GetDate().Format(time.RFC3339)),
+			Message:            types.StringValue(c.GetCommit().GetMessage()),
+			Verified:           types.BoolValue(v.GetVerified()),
+			VerificationReason: types.StringValue(v.GetReason()),
+		})
+	}
+
+	config.ID = types.StringValue(fmt.Sprintf("%s/%s:%s:%s", owner, repo, ref, path))
+	config.Ref = types.StringValue(ref)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &config)...)
+}
+
+// listFileCommits returns up to limit commits reachable from ref that
+// touched the given path, most recent first.
+func listFileCommits(ctx context.Context, c *github.Client, owner, repo, ref, path string, limit int) ([]*github.RepositoryCommit, error) {
+	var r []*github.RepositoryCommit
+	o := &github.CommitsListOptions{
+		SHA:         ref,
+		Path:        path,
+		ListOptions: github.ListOptions{PerPage: min(limit, 100)},
+	}
+	for {
+		v, res, err := c.Repositories.ListCommits(ctx, owner, repo, o)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list commits for %q: %v", path, err)
+		}
+		r = append(r, v...)
+		if len(r) >= limit {
+			return r[:limit], nil
+		}
+		if res.NextPage == 0 {
+			return r, nil
+		}
+		o.Page = res.NextPage
+	}
+}
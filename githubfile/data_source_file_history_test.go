@@ -0,0 +1,52 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestListFileCommits_Limit(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo/commits", func(w http.ResponseWriter, r *http.Request) {
+		if v := r.URL.Query().Get("path"); v != "LICENSE" {
+			t.Errorf("expected path %q, got %q", "LICENSE", v)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s?page=2>; rel="next"`, r.URL.Path))
+			fmt.Fprint(w, `[{"sha":"c1"},{"sha":"c2"}]`)
+			return
+		}
+		fmt.Fprint(w, `[{"sha":"c3"},{"sha":"c4"}]`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	commits, err := listFileCommits(context.Background(), newMockGitHubClient(server), "test-owner", "test-repo", "main", "LICENSE", 3)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(commits) != 3 {
+		t.Fatalf("expected 3 commits, got %d", len(commits))
+	}
+	if v := commits[2].GetSHA(); v != "c3" {
+		t.Fatalf("expected last commit %q, got %q", "c3", v)
+	}
+}
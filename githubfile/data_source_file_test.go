@@ -0,0 +1,96 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadRemoteFile(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":1,"name":"test-repo","default_branch":"main"}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/contents/bin/run.sh", func(w http.ResponseWriter, r *http.Request) {
+		if v := r.URL.Query().Get("ref"); v != "main" {
+			t.Errorf("expected ref %q, got %q", "main", v)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"type":"file","encoding":"base64","size":3,"path":"bin/run.sh","sha":"blob-sha","content":"Zm9v"}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/commits", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[{"sha":"commit-sha","commit":{"tree":{"sha":"root-tree"}}}]`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/git/trees/root-tree", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"sha":"root-tree","tree":[{"path":"bin","type":"tree","mode":"040000","sha":"bin-tree"}]}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/git/trees/bin-tree", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"sha":"bin-tree","tree":[{"path":"run.sh","type":"blob","mode":"100755","sha":"blob-sha","size":3}]}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{
+		githubClient: newMockGitHubClient(server),
+	}
+
+	f, err := readRemoteFile(context.Background(), config, "test-owner", "test-repo", "", "bin/run.sh")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if f.ref != "main" {
+		t.Errorf("expected ref %q, got %q", "main", f.ref)
+	}
+	if f.contents != "foo" {
+		t.Errorf("expected contents %q, got %q", "foo", f.contents)
+	}
+	if f.blobSHA != "blob-sha" {
+		t.Errorf("expected blob SHA %q, got %q", "blob-sha", f.blobSHA)
+	}
+	if f.commitSHA != "commit-sha" {
+		t.Errorf("expected commit SHA %q, got %q", "commit-sha", f.commitSHA)
+	}
+	if f.mode != "100755" {
+		t.Errorf("expected mode %q, got %q", "100755", f.mode)
+	}
+}
+
+func TestReadRemoteFile_Directory(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo/contents/docs", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[{"type":"file","path":"docs/README.md","sha":"blob-sha"}]`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{
+		githubClient: newMockGitHubClient(server),
+	}
+
+	_, err := readRemoteFile(context.Background(), config, "test-owner", "test-repo", "main", "docs")
+	if err == nil || !strings.Contains(err.Error(), "is a directory") {
+		t.Fatalf("expected a directory error, got: %v", err)
+	}
+}
@@ -0,0 +1,387 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v54/github"
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+var (
+	_ datasource.DataSource              = &repositoriesDataSource{}
+	_ datasource.DataSourceWithConfigure = &repositoriesDataSource{}
+)
+
+type repositoriesDataSource struct {
+	config *providerConfiguration
+}
+
+type repositoriesDataSourceModel struct {
+	ID               types.String            `tfsdk:"id"`
+	Owner            types.String            `tfsdk:"owner"`
+	Topics           []types.String          `tfsdk:"topics"`
+	Language         types.String            `tfsdk:"language"`
+	Visibility       types.String            `tfsdk:"visibility"`
+	Archived         types.Bool              `tfsdk:"archived"`
+	Fork             types.Bool              `tfsdk:"fork"`
+	NameRegex        types.String            `tfsdk:"name_regex"`
+	CustomProperties map[string]types.String `tfsdk:"custom_properties"`
+	Repositories     []repositoryModel       `tfsdk:"repositories"`
+}
+
+type repositoryModel struct {
+	Name          types.String   `tfsdk:"name"`
+	FullName      types.String   `tfsdk:"full_name"`
+	DefaultBranch types.String   `tfsdk:"default_branch"`
+	Archived      types.Bool     `tfsdk:"archived"`
+	Topics        []types.String `tfsdk:"topics"`
+}
+
+// repositoryFilter holds the criteria a repository must meet to be listed.
+// Nil and empty fields match every repository.
+type repositoryFilter struct {
+	topics           []string
+	language         string
+	visibility       string
+	archived         *bool
+	fork             *bool
+	nameRegex        *regexp.Regexp
+	customProperties map[string]string
+}
+
+// NewRepositoriesDataSource returns a new repositories data source.
+func NewRepositoriesDataSource() datasource.DataSource {
+	return &repositoriesDataSource{}
+}
+
+func (d *repositoriesDataSource) Metadata(_ context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_repositories"
+}
+
+func (d *repositoriesDataSource) Schema(_ context.Context, _ datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				Computed:    true,
+				Description: "The ID of the data source (the owner).",
+			},
+			"owner": schema.StringAttribute{
+				Required:    true,
+				Description: "The organisation or user whose repositories to list.",
+			},
+			"topics": schema.ListAttribute{
+				Optional:    true,
+				ElementType: types.StringType,
+				Description: "Topics that repositories must all have to be listed.",
+			},
+			"language": schema.StringAttribute{
+				Optional:    true,
+				Description: "The primary language that repositories must have to be listed (case-insensitive).",
+			},
+			"visibility": schema.StringAttribute{
+				Optional:    true,
+				Description: "The visibility that repositories must have to be listed. One of \"public\", \"private\" or \"internal\".",
+				Validators: []validator.String{
+					oneOfValidator("public", "private", "internal"),
+				},
+			},
+			"archived": schema.BoolAttribute{
+				Optional:    true,
+				Description: "If set, only list repositories whose archived status matches.",
+			},
+			"fork": schema.BoolAttribute{
+				Optional:    true,
+				Description: "If set, only list repositories whose fork status matches.",
+			},
+			"name_regex": schema.StringAttribute{
+				Optional:    true,
+				Description: "A regular expression that repository names must match to be listed.",
+			},
+			"custom_properties": schema.MapAttribute{
+				Optional:    true,
+				ElementType: types.StringType,
+				Description: "Custom property values that repositories must have to be listed. Only supported for organisations.",
+			},
+			"repositories": schema.ListNestedAttribute{
+				Computed:    true,
+				Description: "The matching repositories, sorted by name.",
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"name": schema.StringAttribute{
+							Computed:    true,
+							Description: "The name of the repository.",
+						},
+						"full_name": schema.StringAttribute{
+							Computed:    true,
+							Description: "The full name of the repository (format: owner/repo).",
+						},
+						"default_branch": schema.StringAttribute{
+							Computed:    true,
+							Description: "The default branch of the repository.",
+						},
+						"archived": schema.BoolAttribute{
+							Computed:    true,
+							Description: "Whether the repository is archived.",
+						},
+						"topics": schema.ListAttribute{
+							Computed:    true,
+							ElementType: types.StringType,
+							Description: "The topics of the repository.",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (d *repositoriesDataSource) Configure(_ context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+	config, ok := req.ProviderData.(*providerConfiguration)
+	if !ok {
+		resp.Diagnostics.AddError(
+			"Unexpected Provider Data",
+			fmt.Sprintf("Expected *providerConfiguration, got: %T", req.ProviderData),
+		)
+		return
+	}
+	d.config = config
+}
+
+func (d *repositoriesDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	var config repositoriesDataSourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &config)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	f := &repositoryFilter{
+		topics:     stringValues(config.Topics),
+		language:   config.Language.ValueString(),
+		visibility: config.Visibility.ValueString(),
+	}
+	if !config.Archived.IsNull() {
+		f.archived = github.Bool(config.Archived.ValueBool())
+	}
+	if !config.Fork.IsNull() {
+		f.fork = github.Bool(config.Fork.ValueBool())
+	}
+	if v := config.NameRegex.ValueString(); v != "" {
+		r, err := regexp.Compile(v)
+		if err != nil {
+			resp.Diagnostics.AddError("Invalid name_regex", err.Error())
+			return
+		}
+		f.nameRegex = r
+	}
+	if len(config.CustomProperties) > 0 {
+		f.customProperties = make(map[string]string, len(config.CustomProperties))
+		for k, v := range config.CustomProperties {
+			f.customProperties[k] = v.ValueString()
+		}
+	}
+
+	repos, err := listRepositories(ctx, d.config.client(config.Owner.ValueString()), config.Owner.ValueString(), f)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to list repositories", err.Error())
+		return
+	}
+
+	config.Repositories = []repositoryModel{}
+	for _, r := range repos {
+		m := repositoryModel{
+			Name:          types.StringValue(r.GetName()),
+			FullName:      types.StringValue(r.GetFullName()),
+			DefaultBranch: types.StringValue(r.GetDefaultBranch()),
+			Archived:      types.BoolValue(r.GetArchived()),
+			Topics:        []types.String{},
+		}
+		for _, t := range r.Topics {
+			m.Topics = append(m.Topics, types.StringValue(t))
+		}
+		config.Repositories = append(config.Repositories, m)
+	}
+
+	config.ID = types.StringValue(config.Owner.ValueString())
+	resp.Diagnostics.Append(resp.State.Set(ctx, &config)...)
+}
+
+// listRepositories lists the repositories of the given organisation or user
+// that match the given filter, sorted by name.
+func listRepositories(ctx context.Context, c *github.Client, owner string, f *repositoryFilter) ([]*github.Repository, error) {
+	u, _, err := c.Users.Get(ctx, owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve owner %q: %v", owner, err)
+	}
+	isOrg := u.GetType() == "Organization"
+	// Only the repositories of the authenticated user can be listed with
+	// their private ones. Other users' public repositories are all that
+	// GitHub lists, and /user fails for app installations, which are no user.
+	isSelf := false
+	if !isOrg {
+		if me, _, err := c.Users.Get(ctx, ""); err == nil && strings.EqualFold(me.GetLogin(), owner) {
+			isSelf = true
+		}
+	}
+
+	var props map[string]map[string]interface{}
+	if len(f.customProperties) > 0 {
+		if !isOrg {
+			return nil, fmt.Errorf("custom properties are only supported for organisations, but %q is a user", owner)
+		}
+		props, err = listCustomPropertyValues(ctx, c, owner)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var all []*github.Repository
+	page := 1
+	for page != 0 {
+		var (
+			repos []*github.Repository
+			res   *github.Response
+		)
+		lo := github.ListOptions{Page: page, PerPage: 100}
+		if isOrg {
+			repos, res, err = c.Repositories.ListByOrg(ctx, owner, &github.RepositoryListByOrgOptions{
+				Type:        "all",
+				Sort:        "full_name",
+				ListOptions: lo,
+			})
+		} else if isSelf {
+			repos, res, err = c.Repositories.List(ctx, "", &github.RepositoryListOptions{
+				Affiliation: "owner",
+				Sort:        "full_name",
+				ListOptions: lo,
+			})
+		} else {
+			repos, res, err = c.Repositories.List(ctx, owner, &github.RepositoryListOptions{
+				Type:        "owner",
+				Sort:        "full_name",
+				ListOptions: lo,
+			})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list repositories for %q: %v", owner, err)
+		}
+		all = append(all, repos...)
+		page = res.NextPage
+	}
+
+	var r []*github.Repository
+	for _, repo := range all {
+		if isSelf && !strings.EqualFold(repo.GetOwner().GetLogin(), owner) {
+			continue
+		}
+		if f.matches(repo, props[repo.GetName()]) {
+			r = append(r, repo)
+		}
+	}
+	return r, nil
+}
+
+func (f *repositoryFilter) matches(r *github.Repository, props map[string]interface{}) bool {
+	if f.archived != nil && r.GetArchived() != *f.archived {
+		return false
+	}
+	if f.fork != nil && r.GetFork() != *f.fork {
+		return false
+	}
+	if f.visibility != "" && r.GetVisibility() != f.visibility {
+		return false
+	}
+	if f.language != "" && !strings.EqualFold(r.GetLanguage(), f.language) {
+		return false
+	}
+	if f.nameRegex != nil && !f.nameRegex.MatchString(r.GetName()) {
+		return false
+	}
+	for _, t := range f.topics {
+		if !slices.Contains(r.Topics, t) {
+			return false
+		}
+	}
+	for k, v := range f.customProperties {
+		switch p := props[k].(type) {
+		case string:
+			if p != v {
+				return false
+			}
+		case []interface{}:
+			// Multi-select properties match if any of their values match.
+			found := false
+			for _, e := range p {
+				if s, ok := e.(string); ok && s == v {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+type customPropertyValues struct {
+	RepositoryName string `json:"repository_name"`
+	Properties     []struct {
+		PropertyName string      `json:"property_name"`
+		Value        interface{} `json:"value"`
+	} `json:"properties"`
+}
+
+// listCustomPropertyValues returns the custom property values of every
+// repository in the given organisation, keyed by repository name and then by
+// property name.
+func listCustomPropertyValues(ctx context.Context, c *github.Client, org string) (map[string]map[string]interface{}, error) {
+	r := make(map[string]map[string]interface{})
+	page := 1
+	for page != 0 {
+		req, err := c.NewRequest("GET", fmt.Sprintf("orgs/%s/properties/values?per_page=100&page=%d", org, page), nil)
+		if err != nil {
+			return nil, err
+		}
+		var v []*customPropertyValues
+		res, err := c.Do(ctx, req, &v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list custom property values for %q: %v", org, err)
+		}
+		for _, repo := range v {
+			p := make(map[string]interface{}, len(repo.Properties))
+			for _, e := range repo.Properties {
+				p[e.PropertyName] = e.Value
+			}
+			r[repo.RepositoryName] = p
+		}
+		page = res.NextPage
+	}
+	return r, nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/google/go-github/v54/github"
+)
+
+func TestListRepositories(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/test-org", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"login":"test-org","type":"Organization"}`)
+	})
+	mux.HandleFunc("/orgs/test-org/repos", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[
+			{"name":"api-a","default_branch":"main","topics":["go","service"],"language":"Go","visibility":"private"},
+			{"name":"api-b","default_branch":"main","topics":["go","service"],"language":"Go","visibility":"private","archived":true},
+			{"name":"api-c","default_branch":"main","topics":["go"],"language":"Go","visibility":"private"},
+			{"name":"web","default_branch":"master","topics":["go","service"],"language":"TypeScript","visibility":"private"}
+		]`)
+	})
+	mux.HandleFunc("/orgs/test-org/properties/values", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[
+			{"repository_name":"api-a","properties":[{"property_name":"team","value":"payments"}]},
+			{"repository_name":"api-c","properties":[{"property_name":"team","value":"payments"}]}
+		]`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	repos, err := listRepositories(context.Background(), newMockGitHubClient(server), "test-org", &repositoryFilter{
+		topics:           []string{"service"},
+		language:         "go",
+		archived:         github.Bool(false),
+		nameRegex:        regexp.MustCompile("^api-"),
+		customProperties: map[string]string{"team": "payments"},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	var names []string
+	for _, r := range repos {
+		names = append(names, r.GetName())
+	}
+	if expected := []string{"api-a"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected repositories %v, got %v", expected, names)
+	}
+}
+
+func TestListRepositories_AuthenticatedUser(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/test-user", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"login":"test-user","type":"User"}`)
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"login":"Test-User","type":"User"}`)
+	})
+	mux.HandleFunc("/user/repos", func(w http.ResponseWriter, r *http.Request) {
+		if v := r.URL.Query().Get("affiliation"); v != "owner" {
+			t.Errorf("expected repositories with affiliation %q to be listed, got %q", "owner", v)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[
+			{"name":"private","owner":{"login":"test-user"},"visibility":"private"},
+			{"name":"public","owner":{"login":"test-user"},"visibility":"public"}
+		]`)
+	})
+	mux.HandleFunc("/users/test-user/repos", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("expected the repositories of the authenticated user not to be listed as another user's")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	repos, err := listRepositories(context.Background(), newMockGitHubClient(server), "test-user", &repositoryFilter{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	var names []string
+	for _, r := range repos {
+		names = append(names, r.GetName())
+	}
+	if expected := []string{"private", "public"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected repositories %v, got %v", expected, names)
+	}
+}
+
+func TestListRepositories_OtherUser(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/other-user", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"login":"other-user","type":"User"}`)
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"login":"test-user","type":"User"}`)
+	})
+	mux.HandleFunc("/users/other-user/repos", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[{"name":"public","owner":{"login":"other-user"},"visibility":"public"}]`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	repos, err := listRepositories(context.Background(), newMockGitHubClient(server), "other-user", &repositoryFilter{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(repos) != 1 || repos[0].GetName() != "public" {
+		t.Fatalf("expected the public repository of the other user, got %v", repos)
+	}
+}
@@ -0,0 +1,343 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/form3tech-oss/go-github-utils/pkg/branch"
+	"github.com/google/go-github/v54/github"
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	fwpath "github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+var (
+	_ datasource.DataSource                   = &treeDataSource{}
+	_ datasource.DataSourceWithConfigure      = &treeDataSource{}
+	_ datasource.DataSourceWithValidateConfig = &treeDataSource{}
+)
+
+type treeDataSource struct {
+	config *providerConfiguration
+}
+
+type treeDataSourceModel struct {
+	ID              types.String     `tfsdk:"id"`
+	RepositoryOwner types.String     `tfsdk:"repository_owner"`
+	RepositoryName  types.String     `tfsdk:"repository_name"`
+	Ref             types.String     `tfsdk:"ref"`
+	PathPrefix      types.String     `tfsdk:"path_prefix"`
+	Include         []types.String   `tfsdk:"include"`
+	Exclude         []types.String   `tfsdk:"exclude"`
+	Entries         []treeEntryModel `tfsdk:"entries"`
+}
+
+type treeEntryModel struct {
+	Path types.String `tfsdk:"path"`
+	Type types.String `tfsdk:"type"`
+	Mode types.String `tfsdk:"mode"`
+	Size types.Int64  `tfsdk:"size"`
+	SHA  types.String `tfsdk:"sha"`
+}
+
+// NewTreeDataSource returns a new tree data source.
+func NewTreeDataSource() datasource.DataSource {
+	return &treeDataSource{}
+}
+
+func (d *treeDataSource) Metadata(_ context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_tree"
+}
+
+func (d *treeDataSource) Schema(_ context.Context, _ datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				Computed:    true,
+				Description: "The ID of the tree (format: owner/repo:ref:path_prefix).",
+			},
+			"repository_owner": schema.StringAttribute{
+				Required:    true,
+				Description: "The owner of the repository whose tree to list.",
+			},
+			"repository_name": schema.StringAttribute{
+				Required:    true,
+				Description: "The name of the repository whose tree to list.",
+			},
+			"ref": schema.StringAttribute{
+				Optional:    true,
+				Computed:    true,
+				Description: "The branch, tag or commit SHA whose tree to list. Defaults to the repository's default branch.",
+			},
+			"path_prefix": schema.StringAttribute{
+				Optional:    true,
+				Description: "The directory under which to list entries. Defaults to the root of the repository.",
+			},
+			"include": schema.ListAttribute{
+				Optional:    true,
+				ElementType: types.StringType,
+				Description: "Glob patterns that entry paths must match to be listed. \"**\" matches any number of directories. Defaults to every entry.",
+			},
+			"exclude": schema.ListAttribute{
+				Optional:    true,
+				ElementType: types.StringType,
+				Description: "Glob patterns excluding matching entry paths from the listing.",
+			},
+			"entries": schema.ListNestedAttribute{
+				Computed:    true,
+				Description: "The entries in the tree, with paths relative to the root of the repository.",
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"path": schema.StringAttribute{
+							Computed:    true,
+							Description: "The path of the entry.",
+						},
+						"type": schema.StringAttribute{
+							Computed:    true,
+							Description: "The type of the entry (blob, tree or commit).",
+						},
+						"mode": schema.StringAttribute{
+							Computed:    true,
+							Description: "The git file mode of the entry.",
+						},
+						"size": schema.Int64Attribute{
+							Computed:    true,
+							Description: "The size of the entry in bytes. Only set for blobs.",
+						},
+						"sha": schema.StringAttribute{
+							Computed:    true,
+							Description: "The SHA of the object the entry points to.",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (d *treeDataSource) Configure(_ context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+	config, ok := req.ProviderData.(*providerConfiguration)
+	if !ok {
+		resp.Diagnostics.AddError(
+			"Unexpected Provider Data",
+			fmt.Sprintf("Expected *providerConfiguration, got: %T", req.ProviderData),
+		)
+		return
+	}
+	d.config = config
+}
+
+func (d *treeDataSource) ValidateConfig(ctx context.Context, req datasource.ValidateConfigRequest, resp *datasource.ValidateConfigResponse) {
+	for _, a := range []string{"include", "exclude"} {
+		var l types.List
+		resp.Diagnostics.Append(req.Config.GetAttribute(ctx, fwpath.Root(a), &l)...)
+		if l.IsNull() || l.IsUnknown() {
+			continue
+		}
+		for i, v := range l.Elements() {
+			s, ok := v.(types.String)
+			if !ok || s.IsNull() || s.IsUnknown() {
+				continue
+			}
+			if err := validateGlob(s.ValueString()); err != nil {
+				resp.Diagnostics.AddAttributeError(fwpath.Root(a).AtListIndex(i), "Invalid Glob", err.Error())
+			}
+		}
+	}
+}
+
+func (d *treeDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	var config treeDataSourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &config)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	owner := config.RepositoryOwner.ValueString()
+	repo := config.RepositoryName.ValueString()
+	ref := config.Ref.ValueString()
+	if ref == "" {
+		v, err := branch.GetDefaultBranch(ctx, d.config.client(owner), owner, repo)
+		if err != nil {
+			resp.Diagnostics.AddError("Failed to retrieve default branch", err.Error())
+			return
+		}
+		ref = v
+	}
+
+	entries, err := listTree(ctx, d.config.client(owner), owner, repo, ref, config.PathPrefix.ValueString())
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to list tree", err.Error())
+		return
+	}
+
+	include := stringValues(config.Include)
+	exclude := stringValues(config.Exclude)
+	config.Entries = []treeEntryModel{}
+	for _, e := range entries {
+		if !matchesGlobs(e.GetPath(), include, exclude) {
+			continue
+		}
+		m := treeEntryModel{
+			Path: types.StringValue(e.GetPath()),
+			Type: types.StringValue(e.GetType()),
+			Mode: types.StringValue(e.GetMode()),
+			Size: types.Int64Null(),
+			SHA:  types.StringValue(e.GetSHA()),
+		}
+		if e.Size != nil {
+			m.Size = types.Int64Value(int64(e.GetSize()))
+		}
+		config.Entries = append(config.Entries, m)
+	}
+
+	config.ID = types.StringValue(fmt.Sprintf("%s/%s:%s:%s", owner, repo, ref, config.PathPrefix.ValueString()))
+	config.Ref = types.StringValue(ref)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &config)...)
+}
+
+// listTree returns every entry under the given directory of the given ref,
+// with paths relative to the root of the repository.
+func listTree(ctx context.Context, c *github.Client, owner, repo, ref, prefix string) ([]*github.TreeEntry, error) {
+	rc, _, err := c.Repositories.GetCommit(ctx, owner, repo, ref, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve commit for ref %q: %v", ref, err)
+	}
+	s := rc.GetCommit().GetTree().GetSHA()
+
+	base := ""
+	if p := strings.Trim(prefix, "/"); p != "" {
+		e, err := getTreeEntry(ctx, c, owner, repo, s, p)
+		if err != nil {
+			return nil, err
+		}
+		if e.GetType() != "tree" {
+			return nil, fmt.Errorf("%q at ref %q is not a directory", p, ref)
+		}
+		s = e.GetSHA()
+		base = p + "/"
+	}
+	return getTreeRecursive(ctx, c, owner, repo, s, base)
+}
+
+// getTreeRecursive lists the tree with the given SHA recursively. When GitHub
+// truncates the response, the tree is walked one level at a time instead,
+// listing each subtree recursively on its own.
+func getTreeRecursive(ctx context.Context, c *github.Client, owner, repo, sha, base string) ([]*github.TreeEntry, error) {
+	t, _, err := c.Git.GetTree(ctx, owner, repo, sha, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve tree %s: %v", sha, err)
+	}
+	if !t.GetTruncated() {
+		for _, e := range t.Entries {
+			e.Path = github.String(base + e.GetPath())
+		}
+		return t.Entries, nil
+	}
+
+	t, _, err = c.Git.GetTree(ctx, owner, repo, sha, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve tree %s: %v", sha, err)
+	}
+	var r []*github.TreeEntry
+	for _, e := range t.Entries {
+		p := base + e.GetPath()
+		e.Path = github.String(p)
+		r = append(r, e)
+		if e.GetType() != "tree" {
+			continue
+		}
+		v, err := getTreeRecursive(ctx, c, owner, repo, e.GetSHA(), p+"/")
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, v...)
+	}
+	return r, nil
+}
+
+// matchesGlobs reports whether p matches at least one of the include patterns
+// (or there are none) and none of the exclude patterns.
+func matchesGlobs(p string, include, exclude []string) bool {
+	for _, g := range exclude {
+		if matchGlob(g, p) {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, g := range include {
+		if matchGlob(g, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateGlob returns an error if the given pattern is malformed, which
+// matchGlob would otherwise treat as matching nothing.
+func validateGlob(pattern string) error {
+	for _, s := range strings.Split(pattern, "/") {
+		if _, err := path.Match(s, ""); err != nil {
+			return fmt.Errorf("%q is not a valid glob: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchGlob matches p against the given pattern using path.Match semantics
+// for each path segment, with the addition of "**" matching zero or more
+// whole segments.
+func matchGlob(pattern, p string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(p, "/"))
+}
+
+func matchSegments(pattern, p []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(p); i++ {
+				if matchSegments(pattern[1:], p[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(p) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], p[0]); err != nil || !ok {
+			return false
+		}
+		pattern, p = pattern[1:], p[1:]
+	}
+	return len(p) == 0
+}
+
+func stringValues(v []types.String) []string {
+	r := make([]string, 0, len(v))
+	for _, s := range v {
+		r = append(r, s.ValueString())
+	}
+	return r
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestListTree_Truncated(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo/commits/main", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"sha":"commit-sha","commit":{"tree":{"sha":"root-tree"}}}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/git/trees/root-tree", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("recursive") != "" {
+			fmt.Fprint(w, `{"sha":"root-tree","truncated":true,"tree":[{"path":"README.md","type":"blob","sha":"a"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"sha":"root-tree","tree":[{"path":"README.md","type":"blob","sha":"a"},{"path":".github","type":"tree","sha":"github-tree"}]}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/git/trees/github-tree", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"sha":"github-tree","tree":[{"path":"workflows","type":"tree","sha":"b"},{"path":"workflows/ci.yaml","type":"blob","sha":"c"}]}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	entries, err := listTree(context.Background(), newMockGitHubClient(server), "test-owner", "test-repo", "main", "")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	var paths []string
+	for _, e := range entries {
+		paths = append(paths, e.GetPath())
+	}
+	expected := []string{"README.md", ".github", ".github/workflows", ".github/workflows/ci.yaml"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("expected paths %v, got %v", expected, paths)
+	}
+}
+
+func TestMatchesGlobs(t *testing.T) {
+	tests := []struct {
+		path     string
+		include  []string
+		exclude  []string
+		expected bool
+	}{
+		{"README.md", nil, nil, true},
+		{".github/workflows/ci.yaml", []string{".github/workflows/*.yaml"}, nil, true},
+		{".github/workflows/ci.yaml", []string{"*.yaml"}, nil, false},
+		{".github/workflows/ci.yaml", []string{"**/*.yaml"}, nil, true},
+		{"ci.yaml", []string{"**/*.yaml"}, nil, true},
+		{".github/workflows/ci.yaml", []string{"**/*.yaml"}, []string{".github/**"}, false},
+		{"docs/a/b/c.md", []string{"docs/**/c.md"}, nil, true},
+		{"docs/a/b/c.md", []string{"docs/*/c.md"}, nil, false},
+	}
+	for _, tt := range tests {
+		if v := matchesGlobs(tt.path, tt.include, tt.exclude); v != tt.expected {
+			t.Errorf("matchesGlobs(%q, %v, %v) = %t, expected %t", tt.path, tt.include, tt.exclude, v, tt.expected)
+		}
+	}
+}
+
+func TestValidateGlob(t *testing.T) {
+	for _, v := range []string{"*.yaml", "**/*.yaml", ".github/workflows/[a-c]*.yml"} {
+		if err := validateGlob(v); err != nil {
+			t.Errorf("validateGlob(%q): unexpected error: %v", v, err)
+		}
+	}
+	for _, v := range []string{"[", "docs/[a-", `docs\`} {
+		if err := validateGlob(v); err == nil {
+			t.Errorf("validateGlob(%q): expected an error", v)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// diffContextLines is the number of unchanged lines shown around each
+	// change in a unified diff.
+	diffContextLines = 3
+	// maxDiffEdits bounds the number of edits the diff algorithm looks for
+	// before giving up and replacing all the differing lines. The time taken
+	// grows with the number of lines times the number of edits looked for,
+	// while the memory used only grows with the latter.
+	maxDiffEdits = 2000
+)
+
+type diffOpKind int
+
+const (
+	diffEqual diffOpKind = iota
+	diffDelete
+	diffInsert
+)
+
+// diffOp is a single line of an edit script turning one list of lines into
+// another.
+type diffOp struct {
+	kind diffOpKind
+	line string
+}
+
+// splitLines splits s into lines, each keeping its trailing newline if any.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	l := strings.SplitAfter(s, "\n")
+	if l[len(l)-1] == "" {
+		l = l[:len(l)-1]
+	}
+	return l
+}
+
+// diffLines returns a shortest edit script turning a into b, using Myers'
+// algorithm.
+func diffLines(a, b []string) []diffOp {
+	// Common prefixes and suffixes need no searching.
+	var prefix, suffix []diffOp
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		prefix = append(prefix, diffOp{diffEqual, a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		suffix = append([]diffOp{{diffEqual, a[len(a)-1]}}, suffix...)
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	ops := append(prefix, myersDiff(a, b)...)
+	return append(ops, suffix...)
+}
+
+// myersDiff returns a shortest edit script turning a into b, which have no
+// common first line, or one replacing all their lines if that takes more than
+// about maxDiffEdits edits. It uses the linear space variant of Myers'
+// algorithm, which finds the middle snake of a shortest edit script and
+// recurses on either side of it.
+func myersDiff(a, b []string) []diffOp {
+	n := min((len(a)+len(b)+1)/2, (maxDiffEdits+1)/2) + 1
+	d := &differ{
+		limit: n - 1,
+		vf:    make([]int, 2*n+1),
+		vb:    make([]int, 2*n+1),
+	}
+	if d.diff(a, b) {
+		return d.ops
+	}
+
+	// There are too many differences to bother finding the fewest edits.
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, l := range a {
+		ops = append(ops, diffOp{diffDelete, l})
+	}
+	for _, l := range b {
+		ops = append(ops, diffOp{diffInsert, l})
+	}
+	return ops
+}
+
+// differ builds an edit script, holding the furthest reaching paths of the
+// searches for middle snakes, which are reused by each one.
+type differ struct {
+	// limit is the number of edits each search looks for in either
+	// direction before giving up.
+	limit int
+	// vf and vb hold, for each diagonal, the furthest reaching x of the
+	// forward and reverse searches, the latter counted from the end.
+	vf, vb []int
+	ops    []diffOp
+}
+
+// diff appends a shortest edit script turning a into b to d.ops, returning
+// false if it gave up on finding one.
+func (d *differ) diff(a, b []string) bool {
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		d.ops = append(d.ops, diffOp{diffEqual, a[0]})
+		a, b = a[1:], b[1:]
+	}
+	s := 0
+	for s < len(a) && s < len(b) && a[len(a)-1-s] == b[len(b)-1-s] {
+		s++
+	}
+	suffix := a[len(a)-s:]
+	a, b = a[:len(a)-s], b[:len(b)-s]
+
+	switch {
+	case len(a) == 0:
+		for _, l := range b {
+			d.ops = append(d.ops, diffOp{diffInsert, l})
+		}
+	case len(b) == 0:
+		for _, l := range a {
+			d.ops = append(d.ops, diffOp{diffDelete, l})
+		}
+	default:
+		x, y, u, v, ok := d.middleSnake(a, b)
+		if !ok || !d.diff(a[:x], b[:y]) {
+			return false
+		}
+		for _, l := range a[x:u] {
+			d.ops = append(d.ops, diffOp{diffEqual, l})
+		}
+		if !d.diff(a[u:], b[v:]) {
+			return false
+		}
+	}
+
+	for _, l := range suffix {
+		d.ops = append(d.ops, diffOp{diffEqual, l})
+	}
+	return true
+}
+
+// middleSnake returns the start (x, y) and end (u, v) of the middle snake of
+// a shortest edit script turning a into b, found by searching forward from
+// their start and backward from their end until the paths overlap.
+func (d *differ) middleSnake(a, b []string) (x, y, u, v int, ok bool) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+	offset := len(d.vf) / 2
+	d.vf[offset+1], d.vb[offset+1] = 0, 0
+	for e := 0; e <= min((n+m+1)/2, d.limit); e++ {
+		for k := -e; k <= e; k += 2 {
+			var x0 int
+			if k == -e || (k != e && d.vf[offset+k-1] < d.vf[offset+k+1]) {
+				x0 = d.vf[offset+k+1]
+			} else {
+				x0 = d.vf[offset+k-1] + 1
+			}
+			y0 := x0 - k
+			x, y := x0, y0
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			d.vf[offset+k] = x
+			// The reverse search on the same diagonal, counting from the
+			// end, is on diagonal delta-k.
+			if c := delta - k; odd && c >= -(e-1) && c <= e-1 && x+d.vb[offset+c] >= n {
+				return x0, y0, x, y, true
+			}
+		}
+		for c := -e; c <= e; c += 2 {
+			var x0 int
+			if c == -e || (c != e && d.vb[offset+c-1] < d.vb[offset+c+1]) {
+				x0 = d.vb[offset+c+1]
+			} else {
+				x0 = d.vb[offset+c-1] + 1
+			}
+			y0 := x0 - c
+			x, y := x0, y0
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x++
+				y++
+			}
+			d.vb[offset+c] = x
+			if k := delta - c; !odd && k >= -e && k <= e && x+d.vf[offset+k] >= n {
+				return n - x, m - y, n - x0, m - y0, true
+			}
+		}
+	}
+	return 0, 0, 0, 0, false
+}
+
+// unifiedDiff returns the differences between a and b in the unified diff
+// format, labelling them with the given names, or the empty string if there
+// are none.
+func unifiedDiff(a, b, aName, bName string) string {
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	var sb strings.Builder
+	// i is the index of the next op, and aLine and bLine its line numbers in
+	// a and b.
+	i, aLine, bLine := 0, 1, 1
+	for i < len(ops) {
+		if ops[i].kind == diffEqual {
+			i, aLine, bLine = i+1, aLine+1, bLine+1
+			continue
+		}
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", aName, bName)
+		}
+
+		// Find the end of the hunk, merging changes separated by no more
+		// than twice the context.
+		start := max(i-diffContextLines, 0)
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != diffEqual {
+				end++
+				continue
+			}
+			n := 0
+			for end+n < len(ops) && ops[end+n].kind == diffEqual {
+				n++
+			}
+			if end+n == len(ops) || n > 2*diffContextLines {
+				end = min(end+diffContextLines, len(ops))
+				break
+			}
+			end += n
+		}
+
+		aStart, bStart := aLine-(i-start), bLine-(i-start)
+		var aCount, bCount int
+		var body strings.Builder
+		for _, op := range ops[start:end] {
+			l := strings.TrimSuffix(op.line, "\n")
+			switch op.kind {
+			case diffEqual:
+				aCount++
+				bCount++
+				body.WriteString(" " + l + "\n")
+			case diffDelete:
+				aCount++
+				body.WriteString("-" + l + "\n")
+			case diffInsert:
+				bCount++
+				body.WriteString("+" + l + "\n")
+			}
+			if !strings.HasSuffix(op.line, "\n") {
+				body.WriteString("\\ No newline at end of file\n")
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n%s", hunkRange(aStart, aCount), hunkRange(bStart, bCount), body.String())
+
+		for _, op := range ops[i:end] {
+			if op.kind != diffInsert {
+				aLine++
+			}
+			if op.kind != diffDelete {
+				bLine++
+			}
+		}
+		i = end
+	}
+	return sb.String()
+}
+
+// hunkRange formats the range of lines of a hunk in a unified diff.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		// An empty range is identified by the line before it.
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// truncateDiff truncates the given diff to at most size bytes, cutting it at
+// the end of a line, and notes how much of it was left out.
+func truncateDiff(d string, size int) string {
+	if len(d) <= size {
+		return d
+	}
+	v := d[:size]
+	if i := strings.LastIndexByte(v, '\n'); i >= 0 {
+		v = v[:i+1]
+	} else {
+		v = ""
+	}
+	return fmt.Sprintf("%s... (%d more bytes of the diff not shown)\n", v, len(d)-len(v))
+}
@@ -0,0 +1,222 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		a        string
+		b        string
+		expected string
+	}{
+		{
+			name: "equal",
+			a:    "a\nb\n",
+			b:    "a\nb\n",
+		},
+		{
+			name: "change",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			b:    "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			expected: `--- a
++++ b
+@@ -2,7 +2,7 @@
+ 2
+ 3
+ 4
+-5
++five
+ 6
+ 7
+ 8
+`,
+		},
+		{
+			name: "separate hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			b:    "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			expected: `--- a
++++ b
+@@ -1,3 +1,4 @@
++0
+ 1
+ 2
+ 3
+@@ -7,4 +8,3 @@
+ 7
+ 8
+ 9
+-10
+`,
+		},
+		{
+			name: "missing newline",
+			a:    "a\nb",
+			b:    "a\nb\n",
+			expected: `--- a
++++ b
+@@ -1,2 +1,2 @@
+ a
+-b
+\ No newline at end of file
++b
+`,
+		},
+		{
+			name: "from empty",
+			a:    "",
+			b:    "a\n",
+			expected: `--- a
++++ b
+@@ -0,0 +1 @@
++a
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if v := unifiedDiff(tt.a, tt.b, "a", "b"); v != tt.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", tt.expected, v)
+			}
+		})
+	}
+}
+
+func TestDiffLines_Minimal(t *testing.T) {
+	a := splitLines("a\nb\nc\na\nb\nb\na\n")
+	b := splitLines("c\nb\na\nb\na\nc\n")
+	ops := diffLines(a, b)
+
+	var edits int
+	var gotA, gotB []string
+	for _, op := range ops {
+		if op.kind != diffInsert {
+			gotA = append(gotA, op.line)
+		}
+		if op.kind != diffDelete {
+			gotB = append(gotB, op.line)
+		}
+		if op.kind != diffEqual {
+			edits++
+		}
+	}
+	if strings.Join(gotA, "") != strings.Join(a, "") || strings.Join(gotB, "") != strings.Join(b, "") {
+		t.Fatalf("expected the edit script to turn a into b, got %+v", ops)
+	}
+	// The classic example from Myers' paper has a shortest edit script of
+	// five edits.
+	if edits != 5 {
+		t.Errorf("expected 5 edits, got %d", edits)
+	}
+}
+
+func TestDiffLines_Random(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	lines := func() []string {
+		l := make([]string, rnd.Intn(30))
+		for i := range l {
+			l[i] = string(rune('a'+rnd.Intn(4))) + "\n"
+		}
+		return l
+	}
+	for i := 0; i < 500; i++ {
+		a, b := lines(), lines()
+		ops := diffLines(a, b)
+
+		var edits int
+		var gotA, gotB []string
+		for _, op := range ops {
+			if op.kind != diffInsert {
+				gotA = append(gotA, op.line)
+			}
+			if op.kind != diffDelete {
+				gotB = append(gotB, op.line)
+			}
+			if op.kind != diffEqual {
+				edits++
+			}
+		}
+		if strings.Join(gotA, "") != strings.Join(a, "") || strings.Join(gotB, "") != strings.Join(b, "") {
+			t.Fatalf("expected the edit script to turn %q into %q, got %+v", a, b, ops)
+		}
+		// A shortest edit script keeps a longest common subsequence.
+		lcs := make([][]int, len(a)+1)
+		for x := range lcs {
+			lcs[x] = make([]int, len(b)+1)
+		}
+		for x := len(a) - 1; x >= 0; x-- {
+			for y := len(b) - 1; y >= 0; y-- {
+				if a[x] == b[y] {
+					lcs[x][y] = lcs[x+1][y+1] + 1
+				} else {
+					lcs[x][y] = max(lcs[x+1][y], lcs[x][y+1])
+				}
+			}
+		}
+		if expected := len(a) + len(b) - 2*lcs[0][0]; edits != expected {
+			t.Fatalf("expected %d edits turning %q into %q, got %d", expected, a, b, edits)
+		}
+	}
+}
+
+func TestDiffLines_UnrelatedMemory(t *testing.T) {
+	var a, b []string
+	for i := 0; i < 5000; i++ {
+		a = append(a, fmt.Sprintf("a%d\n", i))
+		b = append(b, fmt.Sprintf("b%d\n", i))
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	diffLines(a, b)
+	runtime.ReadMemStats(&after)
+	// The search itself only needs memory for as many diagonals as edits,
+	// on top of the edit script.
+	if v := after.TotalAlloc - before.TotalAlloc; v > 4<<20 {
+		t.Errorf("expected diffing unrelated files to allocate little memory, got %d bytes", v)
+	}
+}
+
+func TestDiffLines_TooManyEdits(t *testing.T) {
+	var a, b []string
+	for i := 0; i < 3*maxDiffEdits; i++ {
+		a = append(a, fmt.Sprintf("a%d\n", i))
+		b = append(b, fmt.Sprintf("b%d\n", i))
+	}
+	ops := diffLines(a, b)
+	if len(ops) != len(a)+len(b) {
+		t.Fatalf("expected every line to be replaced, got %d ops", len(ops))
+	}
+	if ops[0].kind != diffDelete || ops[len(ops)-1].kind != diffInsert {
+		t.Errorf("expected the deletions to precede the insertions")
+	}
+}
+
+func TestTruncateDiff(t *testing.T) {
+	d := "--- a\n+++ b\n@@ -1 +1 @@\n-a\n+b\n"
+	if v := truncateDiff(d, len(d)); v != d {
+		t.Errorf("expected a diff within the size to be kept, got %q", v)
+	}
+	if v, expected := truncateDiff(d, 15), "--- a\n+++ b\n... (18 more bytes of the diff not shown)\n"; v != expected {
+		t.Errorf("expected %q, got %q", expected, v)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/google/go-github/v54/github"
+)
+
+// commitIdentity is the username and email address commits are made as.
+type commitIdentity struct {
+	username string
+	email    string
+}
+
+// identityCache holds the commit identity discovered for each set of
+// credentials, so that each is only discovered once, when first needed.
+type identityCache struct {
+	mu      sync.Mutex
+	entries map[string]*identityEntry
+}
+
+// identityEntry holds the commit identity of a set of credentials once it has
+// been discovered. Its lock is only held while discovering it, so that
+// discovering one identity does not hold up writes using other credentials.
+type identityEntry struct {
+	mu       sync.Mutex
+	identity *commitIdentity
+}
+
+func newIdentityCache() *identityCache {
+	return &identityCache{entries: map[string]*identityEntry{}}
+}
+
+// entry returns the entry of the credentials with the given key.
+func (c *identityCache) entry(k string) *identityEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[k]
+	if !ok {
+		e = &identityEntry{}
+		c.entries[k] = e
+	}
+	return e
+}
+
+// commitIdentity returns the username and email address to commit to the
+// repositories of the given owner as. Those configured take precedence over
+// those of the credentials used for the owner, which are discovered if the
+// provider is configured to.
+func (c *providerConfiguration) commitIdentity(ctx context.Context, owner string) (string, string, error) {
+	u, e := c.githubUsername, c.githubEmail
+	if (u != "" && e != "") || c.identities == nil {
+		return u, e, nil
+	}
+	// Owners without credentials of their own share the default ones.
+	k := strings.ToLower(owner)
+	if _, ok := c.ownerClients[k]; !ok {
+		k = ""
+	}
+
+	ent := c.identities.entry(k)
+	ent.mu.Lock()
+	defer ent.mu.Unlock()
+	if ent.identity == nil {
+		// A failed discovery is not cached, so that the next write tries
+		// again.
+		var v commitIdentity
+		var err error
+		v.username, v.email, err = discoverCommitIdentity(withRequestOwner(ctx, owner), c.client(owner), c.appsClient(owner), c.noreplyDomain)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to discover the commit identity for %q: %v. Configure github_email and github_username explicitly instead", owner, err)
+		}
+		ent.identity = &v
+	}
+	if u == "" {
+		u = ent.identity.username
+	}
+	if e == "" {
+		e = ent.identity.email
+	}
+	return u, e, nil
+}
+
+// discoverCommitIdentity returns the username and email address to commit as
+// when authenticated with the given client. If apps is not nil, the client
+// authenticates as an installation of that app, and the identity is that of
+// the app's bot user. Otherwise it is that of the authenticated user, using
+// their primary email address if it can be read, or their noreply one.
+func discoverCommitIdentity(ctx context.Context, gc, apps *github.Client, noreplyDomain string) (string, string, error) {
+	if apps != nil {
+		a, _, err := apps.Apps.Get(ctx, "")
+		if err != nil {
+			return "", "", fmt.Errorf("failed to retrieve the authenticated app: %v", err)
+		}
+		login := a.GetSlug() + "[bot]"
+		u, _, err := gc.Users.Get(ctx, login)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to retrieve the app's bot user %q: %v", login, err)
+		}
+		return login, fmt.Sprintf("%d+%s@%s", u.GetID(), login, noreplyDomain), nil
+	}
+
+	u, _, err := gc.Users.Get(ctx, "")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to retrieve the authenticated user: %v", err)
+	}
+	// Listing email addresses requires the user:email scope, which the token
+	// may well not have, so failing to do so is not an error.
+	if emails, _, err := gc.Users.ListEmails(ctx, nil); err == nil {
+		for _, e := range emails {
+			if e.GetPrimary() && e.GetVerified() {
+				return u.GetLogin(), e.GetEmail(), nil
+			}
+		}
+	}
+	if u.GetEmail() != "" {
+		return u.GetLogin(), u.GetEmail(), nil
+	}
+	return u.GetLogin(), fmt.Sprintf("%d+%s@%s", u.GetID(), u.GetLogin(), noreplyDomain), nil
+}
+
+// validateGPGKeyEmail checks that one of the identities of the given
+// armored GPG key has the given email address, as GitHub only marks commits
+// as verified when they do.
+func validateGPGKeyEmail(armoredKey, email string) error {
+	l, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armoredKey))
+	if err != nil {
+		return fmt.Errorf("failed to read GPG key: %v", err)
+	}
+	var uids []string
+	for _, e := range l {
+		for _, i := range e.Identities {
+			if strings.EqualFold(i.UserId.Email, email) {
+				return nil
+			}
+			uids = append(uids, i.Name)
+		}
+	}
+	return fmt.Errorf("email %q does not match any identity of the GPG key (%s)", email, strings.Join(uids, ", "))
+}
+
+// readGPGSigningKey reads the given armored GPG key, decrypting it and its
+// subkeys with the given passphrase, for signing commits made outside of
+// commit.CreateCommit.
+func readGPGSigningKey(armoredKey, passphrase string) (*openpgp.Entity, error) {
+	l, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armoredKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GPG key: %v", err)
+	}
+	e := l[0]
+	if e.PrivateKey != nil && e.PrivateKey.Encrypted {
+		if err := e.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+			return nil, fmt.Errorf("failed to decrypt GPG key: %v", err)
+		}
+	}
+	for _, k := range e.Subkeys {
+		if k.PrivateKey != nil && k.PrivateKey.Encrypted {
+			if err := k.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+				return nil, fmt.Errorf("failed to decrypt GPG subkey: %v", err)
+			}
+		}
+	}
+	return e, nil
+}
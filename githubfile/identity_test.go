@@ -0,0 +1,225 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v54/github"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+func TestDiscoverCommitIdentity_User(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"login":"ci-bot","id":42}`)
+	})
+	mux.HandleFunc("/user/emails", func(w http.ResponseWriter, r *http.Request) {
+		// Tokens without the user:email scope cannot list email addresses.
+		w.WriteHeader(http.StatusNotFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	u, e, err := discoverCommitIdentity(context.Background(), newMockGitHubClient(server), nil, "users.noreply.github.com")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if u != "ci-bot" {
+		t.Errorf("expected username %q, got %q", "ci-bot", u)
+	}
+	if expected := "42+ci-bot@users.noreply.github.com"; e != expected {
+		t.Errorf("expected email %q, got %q", expected, e)
+	}
+}
+
+func TestDiscoverCommitIdentity_App(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/app", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":1,"slug":"file-bot"}`)
+	})
+	mux.HandleFunc("/users/file-bot[bot]", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"login":"file-bot[bot]","id":7}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c := newMockGitHubClient(server)
+	u, e, err := discoverCommitIdentity(context.Background(), c, c, "users.noreply.github.com")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if u != "file-bot[bot]" {
+		t.Errorf("expected username %q, got %q", "file-bot[bot]", u)
+	}
+	if expected := "7+file-bot[bot]@users.noreply.github.com"; e != expected {
+		t.Errorf("expected email %q, got %q", expected, e)
+	}
+}
+
+func TestCommitIdentity_PerOwner(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	var lookups int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"login":"ci-bot","id":42,"email":"ci-bot@example.com"}`)
+	})
+	mux.HandleFunc("/user/emails", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/app", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":1,"slug":"file-bot"}`)
+	})
+	mux.HandleFunc("/orgs/other-org/installation", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":8}`)
+	})
+	mux.HandleFunc("/app/installations/8/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"token":"installation-token","expires_at":"2099-01-01T00:00:00Z"}`)
+	})
+	mux.HandleFunc("/users/file-bot[bot]", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&lookups, 1)
+		if v := r.Header.Get("Authorization"); v != "Bearer installation-token" {
+			t.Errorf("expected the bot user to be looked up with the installation token, got %q", v)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"login":"file-bot[bot]","id":7}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	newClient := func(rt http.RoundTripper) *github.Client {
+		c := github.NewClient(&http.Client{Transport: rt})
+		c.BaseURL, _ = url.Parse(server.URL + "/")
+		return c
+	}
+	apps := newClient(&jwtTransport{appID: "1", key: key, base: http.DefaultTransport})
+	config := &providerConfiguration{
+		githubClient:    newMockGitHubClient(server),
+		ownerClients:    map[string]*github.Client{"other-org": newClient(newAppInstallationTransport(apps, 0, http.DefaultTransport))},
+		ownerAppClients: map[string]*github.Client{"other-org": apps},
+		identities:      newIdentityCache(),
+		noreplyDomain:   "users.noreply.github.com",
+	}
+
+	tests := []struct {
+		owner    string
+		username string
+		email    string
+	}{
+		{"test-org", "ci-bot", "ci-bot@example.com"},
+		{"other-org", "file-bot[bot]", "7+file-bot[bot]@users.noreply.github.com"},
+		{"Other-Org", "file-bot[bot]", "7+file-bot[bot]@users.noreply.github.com"},
+	}
+	for _, tt := range tests {
+		u, e, err := config.commitIdentity(context.Background(), tt.owner)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got: %v", tt.owner, err)
+		}
+		if u != tt.username || e != tt.email {
+			t.Errorf("%s: expected identity %q <%s>, got %q <%s>", tt.owner, tt.username, tt.email, u, e)
+		}
+	}
+	if v := atomic.LoadInt32(&lookups); v != 1 {
+		t.Errorf("expected the bot user to be looked up once, got %d", v)
+	}
+}
+
+func TestCommitIdentity_SlowDiscovery(t *testing.T) {
+	// Discovering the default identity stalls until the test ends.
+	stalled := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stalled
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer slow.Close()
+	defer close(stalled)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"login":"other-bot","id":43}`)
+	})
+	fast := httptest.NewServer(mux)
+	defer fast.Close()
+
+	config := &providerConfiguration{
+		githubClient:  newMockGitHubClient(slow),
+		ownerClients:  map[string]*github.Client{"other-org": newMockGitHubClient(fast)},
+		identities:    newIdentityCache(),
+		noreplyDomain: "users.noreply.github.com",
+	}
+
+	go config.commitIdentity(context.Background(), "test-org") //nolint:errcheck
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := config.commitIdentity(context.Background(), "other-org")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected discovering the identity for other-org not to wait for the default one")
+	}
+}
+
+func TestValidateGPGKeyEmail(t *testing.T) {
+	k, err := openpgp.NewEntity("CI Bot", "", "ci-bot@example.com", nil)
+	if err != nil {
+		t.Fatalf("failed to generate GPG key: %v", err)
+	}
+	var b bytes.Buffer
+	w, err := armor.Encode(&b, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatalf("failed to armor GPG key: %v", err)
+	}
+	if err := k.SerializePrivate(w, nil); err != nil {
+		t.Fatalf("failed to serialize GPG key: %v", err)
+	}
+	w.Close()
+
+	if err := validateGPGKeyEmail(b.String(), "CI-Bot@example.com"); err != nil {
+		t.Errorf("expected matching email to be accepted, got: %v", err)
+	}
+	if err := validateGPGKeyEmail(b.String(), "someone@example.com"); err == nil {
+		t.Error("expected mismatched email to be rejected")
+	}
+}
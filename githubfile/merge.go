@@ -0,0 +1,128 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"strings"
+)
+
+const (
+	conflictMarkerOurs   = "<<<<<<< remote"
+	conflictMarkerSep    = "======="
+	conflictMarkerTheirs = ">>>>>>> terraform"
+)
+
+// merge3 performs a line-based three-way merge of the changes made to base
+// by remote and by desired. It returns the merged contents, with any
+// conflicting changes surrounded by conflict markers, and each conflict as
+// it appears in them.
+func merge3(base, remote, desired string) (string, []string) {
+	o := splitLines(base)
+	a := splitLines(remote)
+	b := splitLines(desired)
+	matchA := matchLines(diffLines(o, a), len(o))
+	matchB := matchLines(diffLines(o, b), len(o))
+
+	var sb strings.Builder
+	var conflicts []string
+	i, j, k := 0, 0, 0
+	for {
+		// Copy the lines unchanged on both sides.
+		for i < len(o) && matchA[i] == j && matchB[i] == k {
+			sb.WriteString(o[i])
+			i, j, k = i+1, j+1, k+1
+		}
+		if i == len(o) && j == len(a) && k == len(b) {
+			return sb.String(), conflicts
+		}
+
+		// Find where both sides are back in step with base, which is at its
+		// end if they never are.
+		i2, j2, k2 := i, len(a), len(b)
+		for ; i2 < len(o); i2++ {
+			if matchA[i2] >= 0 && matchB[i2] >= 0 {
+				j2, k2 = matchA[i2], matchB[i2]
+				break
+			}
+		}
+
+		ro, ra, rb := o[i:i2], a[j:j2], b[k:k2]
+		switch {
+		case equalLines(ra, ro):
+			writeLines(&sb, rb)
+		case equalLines(rb, ro), equalLines(ra, rb):
+			writeLines(&sb, ra)
+		default:
+			// The markers must start lines of their own, even where a side
+			// lacks a newline at the end of the file.
+			var c strings.Builder
+			c.WriteString(conflictMarkerOurs + "\n")
+			writeLines(&c, ra)
+			terminateLine(&c)
+			c.WriteString(conflictMarkerSep + "\n")
+			writeLines(&c, rb)
+			terminateLine(&c)
+			c.WriteString(conflictMarkerTheirs + "\n")
+			sb.WriteString(c.String())
+			conflicts = append(conflicts, c.String())
+		}
+		i, j, k = i2, j2, k2
+	}
+}
+
+// matchLines returns, for each of the n lines of the original side of the
+// given edit script, the index of the line of the other side it is kept as,
+// or -1 if it is deleted.
+func matchLines(ops []diffOp, n int) []int {
+	m := make([]int, n)
+	i, j := 0, 0
+	for _, op := range ops {
+		switch op.kind {
+		case diffEqual:
+			m[i] = j
+			i, j = i+1, j+1
+		case diffDelete:
+			m[i] = -1
+			i++
+		case diffInsert:
+			j++
+		}
+	}
+	return m
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func writeLines(sb *strings.Builder, l []string) {
+	for _, v := range l {
+		sb.WriteString(v)
+	}
+}
+
+func terminateLine(sb *strings.Builder) {
+	if s := sb.String(); s != "" && !strings.HasSuffix(s, "\n") {
+		sb.WriteString("\n")
+	}
+}
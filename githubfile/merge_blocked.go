@@ -0,0 +1,291 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v54/github"
+)
+
+// mergeBlockKind is a reason why a pull request cannot be merged.
+type mergeBlockKind string
+
+const (
+	mergeBlockConflict             mergeBlockKind = "merge conflict"
+	mergeBlockBehind               mergeBlockKind = "branch behind base"
+	mergeBlockLocked               mergeBlockKind = "locked branch"
+	mergeBlockRestrictedUpdates    mergeBlockKind = "restricted updates"
+	mergeBlockRequiredReviews      mergeBlockKind = "required reviews"
+	mergeBlockCodeOwners           mergeBlockKind = "code owner review"
+	mergeBlockRequiredStatusChecks mergeBlockKind = "required status checks"
+	mergeBlockSignedCommits        mergeBlockKind = "signed commits"
+	mergeBlockLinearHistory        mergeBlockKind = "linear history"
+	mergeBlockConversations        mergeBlockKind = "conversation resolution"
+)
+
+// mergeBlock is a rule or condition blocking the merge of a pull request.
+type mergeBlock struct {
+	kind   mergeBlockKind
+	detail string
+}
+
+// mergeBlockedError is returned when a pull request opened to write a file
+// could not be merged, describing what blocked it as far as can be told.
+type mergeBlockedError struct {
+	path           string
+	branch         string
+	pullRequestURL string
+	mergeableState string
+	blocks         []mergeBlock
+	err            error
+}
+
+func (e *mergeBlockedError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "the pull request writing %q could not be merged into branch %q: %v", e.path, e.branch, e.err)
+	if e.pullRequestURL != "" {
+		fmt.Fprintf(&sb, "\n\nPull request: %s", e.pullRequestURL)
+	}
+	if len(e.blocks) == 0 {
+		if e.mergeableState != "" {
+			fmt.Fprintf(&sb, "\n\nGitHub reports the pull request's mergeable state as %q.", e.mergeableState)
+		}
+		return sb.String()
+	}
+	sb.WriteString("\n\nIt is blocked by:")
+	for _, b := range e.blocks {
+		fmt.Fprintf(&sb, "\n  - %s: %s", b.kind, b.detail)
+	}
+	return sb.String()
+}
+
+func (e *mergeBlockedError) Unwrap() error {
+	return e.err
+}
+
+// has returns whether the merge was blocked for the given reason.
+func (e *mergeBlockedError) has(k mergeBlockKind) bool {
+	for _, b := range e.blocks {
+		if b.kind == k {
+			return true
+		}
+	}
+	return false
+}
+
+// errMergeFailed is wrapped by the errors of writes whose pull request could
+// not be merged.
+var errMergeFailed = errors.New("failed to merge the pull request")
+
+// wrapMergeFailure returns the given error from commit.CreateCommit wrapping
+// errMergeFailed if it reports that its pull request could not be merged.
+// commit.CreateCommit only tells so by the message of its error, so this is
+// the one place where it is told apart from the other failures.
+func wrapMergeFailure(err error) error {
+	if err == nil || !strings.HasPrefix(err.Error(), "failed to merge PR") {
+		return err
+	}
+	return fmt.Errorf("%w: %v", errMergeFailed, strings.TrimPrefix(strings.TrimPrefix(err.Error(), "failed to merge PR"), ": "))
+}
+
+// mergeWatchTransport is an http.RoundTripper that looks up the pull request
+// whose merge failed before the failure is returned. commit.CreateCommit
+// closes the pull request as soon as it cannot merge it, after which GitHub
+// no longer reports why it could not be merged.
+type mergeWatchTransport struct {
+	base  http.RoundTripper
+	gc    *github.Client
+	owner string
+	repo  string
+	// pullRequest is the pull request whose merge last failed, as it was
+	// while still open, or nil if it could not be looked up.
+	pullRequest *github.PullRequest
+}
+
+// watchMerges returns a client sending its requests the way gc does, and the
+// mergeWatchTransport it sends them through, which looks up the pull requests
+// of the given repository whose merge fails.
+func watchMerges(gc *github.Client, owner, repo string) (*github.Client, *mergeWatchTransport) {
+	hc := gc.Client()
+	t := &mergeWatchTransport{base: hc.Transport, gc: gc, owner: owner, repo: repo}
+	if t.base == nil {
+		t.base = http.DefaultTransport
+	}
+	hc.Transport = t
+	wc := github.NewClient(hc)
+	wc.BaseURL, wc.UploadURL, wc.UserAgent = gc.BaseURL, gc.UploadURL, gc.UserAgent
+	return wc, t
+}
+
+func (t *mergeWatchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || req.Method != http.MethodPut || resp.StatusCode < http.StatusMultipleChoices {
+		return resp, err
+	}
+	m := mergePathRegexp.FindStringSubmatch(req.URL.Path)
+	if m == nil {
+		return resp, err
+	}
+	n, _ := strconv.Atoi(m[1])
+	pr, _, gerr := t.gc.PullRequests.Get(req.Context(), t.owner, t.repo, n)
+	if gerr != nil {
+		log.Printf("[WARN] Failed to look up pull request #%d of %s/%s: %v", n, t.owner, t.repo, gerr)
+	}
+	t.pullRequest = pr
+	return resp, err
+}
+
+// diagnoseMergeFailure looks up why the given pull request could not be
+// merged, returning a mergeBlockedError wrapping err which describes it. The
+// pull request must have been looked up while still open, as by a
+// mergeWatchTransport, for its mergeable state to tell anything; if it could
+// not be, err is only wrapped.
+func diagnoseMergeFailure(ctx context.Context, c *providerConfiguration, f *file, pr *github.PullRequest, err error) error {
+	gc := c.client(f.repositoryOwner)
+	e := &mergeBlockedError{path: f.path, branch: f.branch, err: err}
+	if pr == nil {
+		return e
+	}
+	e.pullRequestURL = pr.GetHTMLURL()
+	e.mergeableState = pr.GetMergeableState()
+	switch e.mergeableState {
+	case "dirty":
+		e.blocks = append(e.blocks, mergeBlock{mergeBlockConflict, "the pull request conflicts with the base branch"})
+	case "behind":
+		e.blocks = append(e.blocks, mergeBlock{mergeBlockBehind, "the base branch requires pull requests to be up to date with it"})
+	}
+
+	rules := branchRules(ctx, gc, f.repositoryOwner, f.repositoryName, f.branch)
+	if rules.locked {
+		e.blocks = append(e.blocks, mergeBlock{mergeBlockLocked, "the branch is locked"})
+	}
+	if rules.restrictedUpdates {
+		e.blocks = append(e.blocks, mergeBlock{mergeBlockRestrictedUpdates, "a ruleset only lets those allowed to bypass it update the branch"})
+	}
+	head := pr.GetHead().GetSHA()
+	if rules.requiredReviews > 0 || rules.requiredCodeOwners {
+		approvals := approvingReviews(ctx, gc, f.repositoryOwner, f.repositoryName, pr.GetNumber())
+		if approvals < rules.requiredReviews {
+			e.blocks = append(e.blocks, mergeBlock{mergeBlockRequiredReviews, fmt.Sprintf("%d approving reviews are required, and %d were given", rules.requiredReviews, approvals)})
+		}
+		// Which of the reviewers own the changed files cannot be told, so
+		// only a pull request which nobody approved is known to lack them.
+		if rules.requiredCodeOwners && approvals == 0 {
+			e.blocks = append(e.blocks, mergeBlock{mergeBlockCodeOwners, "a review from the code owners of the changed files (see CODEOWNERS) is required"})
+		}
+	}
+	if len(rules.requiredStatusChecks) > 0 {
+		states := statusCheckStates(ctx, gc, f.repositoryOwner, f.repositoryName, head)
+		var l []string
+		for _, v := range rules.requiredStatusChecks {
+			s, ok := states[v]
+			if !ok {
+				s = "expected"
+			}
+			if !passingStatusCheckStates[s] {
+				l = append(l, fmt.Sprintf("%s (%s)", v, s))
+			}
+		}
+		if len(l) > 0 {
+			e.blocks = append(e.blocks, mergeBlock{mergeBlockRequiredStatusChecks, strings.Join(l, ", ")})
+		}
+	}
+	if rules.requiredSignatures {
+		if c.gpgSecretKey == "" {
+			e.blocks = append(e.blocks, mergeBlock{mergeBlockSignedCommits, "commits must be signed, but no gpg_secret_key is configured"})
+		} else if cm, _, err := gc.Git.GetCommit(ctx, f.repositoryOwner, f.repositoryName, head); err == nil && !cm.GetVerification().GetVerified() {
+			e.blocks = append(e.blocks, mergeBlock{mergeBlockSignedCommits, fmt.Sprintf("commits must be signed with a verified key, but the signature of the commit could not be verified (%s)", cm.GetVerification().GetReason())})
+		}
+	}
+	// The pull request is always merged with a merge commit.
+	if rules.requiredLinearHistory {
+		e.blocks = append(e.blocks, mergeBlock{mergeBlockLinearHistory, "merge commits are not allowed, but the provider merges its pull requests with one"})
+	}
+	// Only a pull request with review comments can have conversations left
+	// to resolve.
+	if rules.requiredThreadResolved && pr.GetReviewComments() > 0 {
+		e.blocks = append(e.blocks, mergeBlock{mergeBlockConversations, fmt.Sprintf("conversations on the pull request must be resolved, and it has %d review comments", pr.GetReviewComments())})
+	}
+	return e
+}
+
+// passingStatusCheckStates are the states of the commit statuses and check
+// runs which satisfy a required status check.
+var passingStatusCheckStates = map[string]bool{
+	"success": true,
+	"neutral": true,
+	"skipped": true,
+}
+
+// approvingReviews returns the number of reviewers whose latest review of the
+// given pull request approves it.
+func approvingReviews(ctx context.Context, gc *github.Client, owner, repo string, number int) int {
+	latest := map[string]string{}
+	o := &github.ListOptions{PerPage: 100}
+	for {
+		v, res, err := gc.PullRequests.ListReviews(ctx, owner, repo, number, o)
+		if err != nil {
+			log.Printf("[WARN] Failed to list the reviews of pull request #%d of %s/%s: %v", number, owner, repo, err)
+			break
+		}
+		for _, r := range v {
+			// Comments leave the verdict of earlier reviews standing.
+			if s := r.GetState(); s != "COMMENTED" && s != "PENDING" {
+				latest[r.GetUser().GetLogin()] = s
+			}
+		}
+		if res.NextPage == 0 {
+			break
+		}
+		o.Page = res.NextPage
+	}
+	n := 0
+	for _, s := range latest {
+		if s == "APPROVED" {
+			n++
+		}
+	}
+	return n
+}
+
+// statusCheckStates returns the state of each commit status and check run of
+// the given commit, keyed by context or name. Check runs which have not
+// completed are reported by their status, and those which have by their
+// conclusion.
+func statusCheckStates(ctx context.Context, gc *github.Client, owner, repo, sha string) map[string]string {
+	states := map[string]string{}
+	if s, _, err := gc.Repositories.GetCombinedStatus(ctx, owner, repo, sha, nil); err == nil {
+		for _, v := range s.Statuses {
+			states[v.GetContext()] = v.GetState()
+		}
+	}
+	if r, _, err := gc.Checks.ListCheckRunsForRef(ctx, owner, repo, sha, nil); err == nil {
+		for _, v := range r.CheckRuns {
+			s := v.GetStatus()
+			if s == "completed" {
+				s = v.GetConclusion()
+			}
+			states[v.GetName()] = s
+		}
+	}
+	return states
+}
@@ -0,0 +1,166 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v54/github"
+)
+
+func TestDiagnoseMergeFailure(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo/pulls/9", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"number":9,"html_url":"https://github.com/test-owner/test-repo/pull/9","mergeable_state":"blocked","head":{"sha":"head-sha"},"review_comments":0}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/pulls/9/reviews", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[
+			{"user":{"login":"alice"},"state":"APPROVED"},
+			{"user":{"login":"bob"},"state":"APPROVED"},
+			{"user":{"login":"bob"},"state":"CHANGES_REQUESTED"},
+			{"user":{"login":"alice"},"state":"COMMENTED"}
+		]`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/git/commits/head-sha", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"sha":"head-sha","verification":{"verified":true,"reason":"valid"}}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/branches/main/protection", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"required_status_checks":{"strict":false,"contexts":["ci/build","lint","test"]},"required_pull_request_reviews":{"required_approving_review_count":2,"require_code_owner_reviews":true},"required_signatures":{"enabled":true},"required_conversation_resolution":{"enabled":true}}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/rules/branches/main", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[]`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/commits/head-sha/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"state":"pending","statuses":[{"context":"ci/build","state":"pending"}]}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/commits/head-sha/check-runs", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"total_count":1,"check_runs":[{"name":"lint","status":"completed","conclusion":"neutral"}]}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{githubClient: newMockGitHubClient(server), gpgSecretKey: "key"}
+	f := &file{repositoryOwner: "test-owner", repositoryName: "test-repo", branch: "main", path: "a.txt"}
+	pr, _, err := config.githubClient.PullRequests.Get(context.Background(), "test-owner", "test-repo", 9)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	err = diagnoseMergeFailure(context.Background(), config, f, pr, wrapMergeFailure(errors.New("failed to merge PR: HTTP 405: blocked")))
+
+	var mbe *mergeBlockedError
+	if !errors.As(err, &mbe) {
+		t.Fatalf("expected a merge blocked error, got: %v", err)
+	}
+	if !errors.Is(err, errMergeFailed) {
+		t.Errorf("expected the error to wrap errMergeFailed, got: %v", err)
+	}
+	for _, k := range []mergeBlockKind{mergeBlockRequiredReviews, mergeBlockRequiredStatusChecks} {
+		if !mbe.has(k) {
+			t.Errorf("expected the merge to be blocked by %s, got %+v", k, mbe.blocks)
+		}
+	}
+	// Those rules are met, or cannot be told to be unmet.
+	for _, k := range []mergeBlockKind{mergeBlockCodeOwners, mergeBlockSignedCommits, mergeBlockConversations} {
+		if mbe.has(k) {
+			t.Errorf("expected the merge not to be blocked by %s, got %+v", k, mbe.blocks)
+		}
+	}
+	for _, v := range []string{"https://github.com/test-owner/test-repo/pull/9", "ci/build (pending)", "test (expected)", "2 approving reviews are required, and 1 were given"} {
+		if !strings.Contains(err.Error(), v) {
+			t.Errorf("expected the error to mention %q, got: %v", v, err)
+		}
+	}
+	if strings.Contains(err.Error(), "lint") {
+		t.Errorf("expected the error not to mention the neutral check, got: %v", err)
+	}
+}
+
+func TestWatchMerges_ClosedPullRequest(t *testing.T) {
+	closed := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo/pulls/9/merge", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"message":"Pull Request is not mergeable"}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/pulls/9", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPatch {
+			closed = true
+		}
+		// GitHub stops computing the mergeable state of closed pull
+		// requests.
+		if closed {
+			fmt.Fprint(w, `{"number":9,"state":"closed","mergeable_state":"unknown","head":{"sha":"head-sha"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"number":9,"state":"open","mergeable_state":"dirty","head":{"sha":"head-sha"}}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/branches/main/protection", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/rules/branches/main", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[]`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{githubClient: newMockGitHubClient(server)}
+	f := &file{repositoryOwner: "test-owner", repositoryName: "test-repo", branch: "main", path: "a.txt"}
+
+	// As commit.CreateCommit does, close the pull request as soon as merging
+	// it fails, before the failure is diagnosed.
+	wc, mw := watchMerges(config.githubClient, "test-owner", "test-repo")
+	if _, _, err := wc.PullRequests.Merge(context.Background(), "test-owner", "test-repo", 9, "", nil); err == nil {
+		t.Fatal("expected the merge to fail")
+	}
+	if _, _, err := wc.PullRequests.Edit(context.Background(), "test-owner", "test-repo", 9, &github.PullRequest{State: github.String("closed")}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	err := diagnoseMergeFailure(context.Background(), config, f, mw.pullRequest, wrapMergeFailure(errors.New("failed to merge PR: HTTP 405: not mergeable")))
+	var mbe *mergeBlockedError
+	if !errors.As(err, &mbe) {
+		t.Fatalf("expected a merge blocked error, got: %v", err)
+	}
+	if !mbe.has(mergeBlockConflict) {
+		t.Errorf("expected the merge to be blocked by a conflict, got %+v (mergeable state %q)", mbe.blocks, mbe.mergeableState)
+	}
+}
+
+func TestWrapMergeFailure(t *testing.T) {
+	if err := wrapMergeFailure(errors.New("failed to merge PR: HTTP 405: blocked")); !errors.Is(err, errMergeFailed) {
+		t.Errorf("expected a failure to merge to wrap errMergeFailed, got: %v", err)
+	}
+	if err := wrapMergeFailure(errors.New("failed to create ref")); errors.Is(err, errMergeFailed) {
+		t.Errorf("expected other failures not to wrap errMergeFailed, got: %v", err)
+	}
+	if err := wrapMergeFailure(nil); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"testing"
+)
+
+func TestMerge3(t *testing.T) {
+	tests := []struct {
+		name      string
+		base      string
+		remote    string
+		desired   string
+		expected  string
+		conflicts int
+	}{
+		{
+			name:     "unchanged remote",
+			base:     "a\nb\nc\n",
+			remote:   "a\nb\nc\n",
+			desired:  "a\nB\nc\n",
+			expected: "a\nB\nc\n",
+		},
+		{
+			name:     "unchanged desired",
+			base:     "a\nb\nc\n",
+			remote:   "a\nb\nc\nd\n",
+			desired:  "a\nb\nc\n",
+			expected: "a\nb\nc\nd\n",
+		},
+		{
+			name:     "separate changes",
+			base:     "a\nb\nc\nd\ne\n",
+			remote:   "a\nB\nc\nd\ne\n",
+			desired:  "a\nb\nc\nD\ne\nf\n",
+			expected: "a\nB\nc\nD\ne\nf\n",
+		},
+		{
+			name:     "same change",
+			base:     "a\nb\nc\n",
+			remote:   "a\nB\nc\n",
+			desired:  "a\nB\nc\n",
+			expected: "a\nB\nc\n",
+		},
+		{
+			name:     "from empty",
+			base:     "",
+			remote:   "",
+			desired:  "a\n",
+			expected: "a\n",
+		},
+		{
+			name:      "conflict",
+			base:      "a\nb\nc\n",
+			remote:    "a\nremote\nc\n",
+			desired:   "a\nterraform\nc\n",
+			expected:  "a\n<<<<<<< remote\nremote\n=======\nterraform\n>>>>>>> terraform\nc\n",
+			conflicts: 1,
+		},
+		{
+			name:      "conflict without newline at end of file",
+			base:      "a\nb",
+			remote:    "a\nremote",
+			desired:   "a\nterraform",
+			expected:  "a\n<<<<<<< remote\nremote\n=======\nterraform\n>>>>>>> terraform\n",
+			conflicts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, conflicts := merge3(tt.base, tt.remote, tt.desired)
+			if v != tt.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", tt.expected, v)
+			}
+			if len(conflicts) != tt.conflicts {
+				t.Errorf("expected %d conflicts, got %q", tt.conflicts, conflicts)
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v54/github"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+)
+
+// preflightProblem is a problem found by the preflight checks which would
+// make writing to a branch fail.
+type preflightProblem struct {
+	// fatal is whether the write is certain to fail, rather than only likely
+	// to.
+	fatal bool
+	// attribute is the attribute of the file resource the problem is about.
+	attribute string
+	summary   string
+	detail    string
+	// missingBranch is whether the problem is that the branch does not
+	// exist.
+	missingBranch bool
+	// checkFailed is whether the problem is that the checks could not be
+	// run, e.g. because of a network error.
+	checkFailed bool
+}
+
+// preflightCache holds the problems found by the preflight checks of each
+// branch, so that each branch is only checked once per plan however many
+// files are written to it.
+type preflightCache struct {
+	mu       sync.Mutex
+	branches map[string]*preflightResult
+}
+
+type preflightResult struct {
+	mu       sync.Mutex
+	done     bool
+	problems []preflightProblem
+}
+
+func newPreflightCache() *preflightCache {
+	return &preflightCache{branches: map[string]*preflightResult{}}
+}
+
+// check returns the problems with writing to the given branch, running the
+// preflight checks if they have not been run yet.
+func (p *preflightCache) check(ctx context.Context, c *providerConfiguration, owner, repo, branch string) []preflightProblem {
+	k := strings.ToLower(owner+"/"+repo) + ":" + branch
+	p.mu.Lock()
+	r, ok := p.branches[k]
+	if !ok {
+		r = &preflightResult{}
+		p.branches[k] = r
+	}
+	p.mu.Unlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done {
+		return r.problems
+	}
+	problems := preflightChecks(ctx, c, owner, repo, branch)
+	// Failing to run the checks, e.g. because the context of the caller
+	// was cancelled, is only reported to that caller, so that the next file
+	// written to the branch runs them again.
+	if ctx.Err() != nil {
+		return problems
+	}
+	for _, v := range problems {
+		if v.checkFailed {
+			return problems
+		}
+	}
+	r.done, r.problems = true, problems
+	return problems
+}
+
+// preflightDiagnostics returns the problems with writing to the given branch
+// as diagnostics, leaving out the branch not existing if it is to be
+// created.
+func preflightDiagnostics(problems []preflightProblem, createBranch bool) diag.Diagnostics {
+	var diags diag.Diagnostics
+	for _, p := range problems {
+		switch {
+		case p.missingBranch && createBranch:
+		case p.fatal:
+			diags.AddAttributeError(path.Root(p.attribute), p.summary, p.detail)
+		default:
+			diags.AddAttributeWarning(path.Root(p.attribute), p.summary, p.detail)
+		}
+	}
+	return diags
+}
+
+// preflightChecks checks that the given repository exists, is not archived
+// and can be pushed to, and that the given branch exists and has no branch
+// protection or ruleset that will stop the pull requests opened by the
+// provider from being merged.
+func preflightChecks(ctx context.Context, c *providerConfiguration, owner, repo, branch string) []preflightProblem {
+	gc := c.client(owner)
+	name := owner + "/" + repo
+
+	r, res, err := gc.Repositories.Get(ctx, owner, repo)
+	if err != nil {
+		if res != nil && res.StatusCode == http.StatusNotFound {
+			return []preflightProblem{{
+				fatal:     true,
+				attribute: "repository_name",
+				summary:   "Repository Not Found",
+				detail:    fmt.Sprintf("Repository %s does not exist, or the configured credentials cannot access it.", name),
+			}}
+		}
+		return []preflightProblem{preflightCheckFailed(name, err)}
+	}
+	if r.GetArchived() {
+		return []preflightProblem{{
+			fatal:     true,
+			attribute: "repository_name",
+			summary:   "Repository Archived",
+			detail:    fmt.Sprintf("Repository %s is archived, so its files cannot be changed.", name),
+		}}
+	}
+	// Permissions are only returned for users, not for apps.
+	if p := r.GetPermissions(); p != nil && !p["push"] {
+		return []preflightProblem{{
+			fatal:     true,
+			attribute: "repository_name",
+			summary:   "Insufficient Permissions",
+			detail:    fmt.Sprintf("The configured credentials cannot push to repository %s, so they cannot open the pull requests through which files are changed.", name),
+		}}
+	}
+
+	if _, res, err := gc.Repositories.GetBranch(ctx, owner, repo, branch, true); err != nil {
+		if res != nil && res.StatusCode == http.StatusNotFound {
+			// The first write to an empty repository creates the branch.
+			if _, _, err := gc.Git.GetRef(ctx, owner, repo, "heads/"+branch); isEmptyRepository(err) {
+				return nil
+			}
+			return []preflightProblem{{
+				fatal:         true,
+				attribute:     "branch",
+				summary:       "Branch Not Found",
+				detail:        fmt.Sprintf("Branch %q does not exist in repository %s. Set create_branch to create it.", branch, name),
+				missingBranch: true,
+			}}
+		}
+		return []preflightProblem{preflightCheckFailed(name, err)}
+	}
+
+	return branchRuleProblems(c, name, branch, branchRules(ctx, gc, owner, repo, branch))
+}
+
+func preflightCheckFailed(name string, err error) preflightProblem {
+	return preflightProblem{
+		attribute:   "repository_name",
+		summary:     "Preflight Check Failed",
+		detail:      fmt.Sprintf("Failed to check whether files in repository %s can be changed: %v", name, err),
+		checkFailed: true,
+	}
+}
+
+// branchRuleSet sums up the rules of the branch protection and rulesets of a
+// branch that matter to the pull requests opened by the provider.
+type branchRuleSet struct {
+	locked                 bool
+	requiredSignatures     bool
+	requiredReviews        int
+	requiredCodeOwners     bool
+	requiredStatusChecks   []string
+	requiredLinearHistory  bool
+	restrictedUpdates      bool
+	requiredThreadResolved bool
+}
+
+// branchRules returns the rules which apply to the given branch, from its
+// branch protection and from the rulesets of its repository. Rules which
+// cannot be read, e.g. because reading branch protection requires admin
+// access, are left out.
+func branchRules(ctx context.Context, gc *github.Client, owner, repo, branch string) branchRuleSet {
+	var s branchRuleSet
+	if p, _, err := gc.Repositories.GetBranchProtection(ctx, owner, repo, branch); err == nil {
+		s.locked = p.GetLockBranch().GetEnabled()
+		s.requiredSignatures = p.GetRequiredSignatures().GetEnabled()
+		if v := p.GetRequiredPullRequestReviews(); v != nil {
+			s.requiredReviews = v.RequiredApprovingReviewCount
+			s.requiredCodeOwners = v.RequireCodeOwnerReviews
+		}
+		if v := p.GetRequiredStatusChecks(); v != nil {
+			s.requiredStatusChecks = append(s.requiredStatusChecks, v.Contexts...)
+			for _, c := range v.Checks {
+				s.requiredStatusChecks = append(s.requiredStatusChecks, c.Context)
+			}
+		}
+		s.requiredLinearHistory = p.RequireLinearHistory != nil && p.RequireLinearHistory.Enabled
+		s.requiredThreadResolved = p.RequiredConversationResolution != nil && p.RequiredConversationResolution.Enabled
+	}
+
+	rules, _, err := gc.Repositories.GetRulesForBranch(ctx, owner, repo, branch)
+	if err != nil {
+		return s
+	}
+	for _, r := range rules {
+		switch r.Type {
+		case "required_signatures":
+			s.requiredSignatures = true
+		case "required_linear_history":
+			s.requiredLinearHistory = true
+		case "update":
+			s.restrictedUpdates = true
+		case "pull_request":
+			var p github.PullRequestRuleParameters
+			if r.Parameters != nil && json.Unmarshal(*r.Parameters, &p) == nil {
+				s.requiredReviews = max(s.requiredReviews, p.RequiredApprovingReviewCount)
+				s.requiredCodeOwners = s.requiredCodeOwners || p.RequireCodeOwnerReview
+				s.requiredThreadResolved = s.requiredThreadResolved || p.RequiredReviewThreadResolution
+			}
+		case "required_status_checks":
+			var p github.RequiredStatusChecksRuleParameters
+			if r.Parameters != nil && json.Unmarshal(*r.Parameters, &p) == nil {
+				for _, c := range p.RequiredStatusChecks {
+					s.requiredStatusChecks = append(s.requiredStatusChecks, c.Context)
+				}
+			}
+		}
+	}
+	return s
+}
+
+// branchRuleProblems returns the problems the given rules of a branch cause
+// the pull requests opened by the provider, which are merged right away with
+// a merge commit.
+func branchRuleProblems(c *providerConfiguration, name, branch string, s branchRuleSet) []preflightProblem {
+	var problems []preflightProblem
+	add := func(fatal bool, summary, detail string, args ...interface{}) {
+		problems = append(problems, preflightProblem{
+			fatal:     fatal,
+			attribute: "branch",
+			summary:   summary,
+			detail:    fmt.Sprintf("Branch %q of repository %s ", branch, name) + fmt.Sprintf(detail, args...),
+		})
+	}
+
+	if s.locked {
+		add(true, "Branch Locked", "is locked, so no pull request can be merged into it.")
+	}
+	if s.restrictedUpdates {
+		add(true, "Branch Updates Restricted", "has a ruleset restricting updates, so pull requests can only be merged into it by those allowed to bypass it.")
+	}
+	if s.requiredSignatures && c.gpgSecretKey == "" {
+		add(true, "Signed Commits Required", "requires signed commits, but no gpg_secret_key is configured to sign them with.")
+	}
+	if s.requiredReviews > 0 || s.requiredCodeOwners {
+		add(false, "Reviews Required", "requires pull requests to be approved (%d approving reviews, code owner review %t), so merging them will fail unless the configured credentials can bypass the requirement.",
+			s.requiredReviews, s.requiredCodeOwners)
+	}
+	if len(s.requiredStatusChecks) > 0 {
+		add(false, "Status Checks Required", "requires status checks (%s) to pass, which they will not have done when the provider merges its pull requests unless the configured credentials can bypass the requirement.",
+			strings.Join(s.requiredStatusChecks, ", "))
+	}
+	if s.requiredThreadResolved {
+		add(false, "Conversation Resolution Required", "requires conversations on pull requests to be resolved before merging them.")
+	}
+	if s.requiredLinearHistory {
+		add(false, "Linear History Required", "requires a linear history, which the merge commits the provider creates when merging its pull requests break.")
+	}
+	return problems
+}
@@ -0,0 +1,114 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPreflightChecks_ArchivedRepo(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"name":"test-repo","archived":true}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{githubClient: newMockGitHubClient(server)}
+	problems := preflightChecks(context.Background(), config, "test-owner", "test-repo", "main")
+	if len(problems) != 1 || !problems[0].fatal || problems[0].summary != "Repository Archived" {
+		t.Errorf("expected the repository to be reported as archived, got %+v", problems)
+	}
+}
+
+func TestPreflightChecks_BranchRules(t *testing.T) {
+	var calls int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"name":"test-repo","permissions":{"push":true}}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/branches/main", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"name":"main"}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/branches/main/protection", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"required_pull_request_reviews":{"required_approving_review_count":2}}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/rules/branches/main", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[{"type":"required_signatures"}]`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{githubClient: newMockGitHubClient(server)}
+	c := newPreflightCache()
+	for i := 0; i < 2; i++ {
+		problems := c.check(context.Background(), config, "test-owner", "test-repo", "main")
+		if len(problems) != 2 {
+			t.Fatalf("expected 2 problems, got %+v", problems)
+		}
+		if p := problems[0]; !p.fatal || p.summary != "Signed Commits Required" {
+			t.Errorf("expected missing signatures to be fatal, got %+v", p)
+		}
+		if p := problems[1]; p.fatal || p.summary != "Reviews Required" {
+			t.Errorf("expected required reviews to be a warning, got %+v", p)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected the checks to run once, ran %d times", calls)
+	}
+}
+
+func TestPreflightCache_DoesNotCacheFailures(t *testing.T) {
+	var calls int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"name":"test-repo","archived":true}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{githubClient: newMockGitHubClient(server)}
+	p := newPreflightCache()
+
+	// The first caller's context is cancelled before the checks run.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	problems := p.check(ctx, config, "test-owner", "test-repo", "main")
+	if len(problems) != 1 || problems[0].summary != "Preflight Check Failed" {
+		t.Fatalf("expected the checks to fail, got %+v", problems)
+	}
+
+	for i := 0; i < 2; i++ {
+		problems = p.check(context.Background(), config, "test-owner", "test-repo", "main")
+		if len(problems) != 1 || problems[0].summary != "Repository Archived" {
+			t.Errorf("expected the repository to be reported as archived, got %+v", problems)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected the checks to run once more after failing, got %d runs", calls)
+	}
+}
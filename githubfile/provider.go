@@ -17,7 +17,10 @@ package githubfile
 import (
 	"context"
 	"encoding/base64"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/google/go-github/v54/github"
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
@@ -25,29 +28,94 @@ import (
 	"github.com/hashicorp/terraform-plugin-framework/provider/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/types"
-	"golang.org/x/oauth2"
 )
 
 var _ provider.Provider = &githubfileProvider{}
 
+// defaultBranchPrefix is the default prefix of the names of the working
+// branches from which pull requests are opened.
+const defaultBranchPrefix = "terraform-provider-githubfile-"
+
+// defaultPlanDiffMaxSize is the default size, in bytes, beyond which the
+// diffs of contents shown in plans are truncated.
+const defaultPlanDiffMaxSize = 8192
+
 type providerConfiguration struct {
+	branchPrefix        string
 	commitMessagePrefix string
 	githubClient        *github.Client
-	githubEmail         string
-	githubUsername      string
-	gpgPassphrase       string
-	gpgSecretKey        string
+	// githubAppsClient authenticates as the app itself when githubClient
+	// authenticates as an app installation, and is nil otherwise.
+	githubAppsClient *github.Client
+	githubEmail      string
+	githubUsername   string
+	// identities holds the commit identities discovered for the
+	// credentials in use, or is nil if the commit identity is not
+	// discovered.
+	identities      *identityCache
+	gpgPassphrase   string
+	gpgSecretKey    string
+	noreplyDomain   string
+	ownerClients    map[string]*github.Client
+	ownerAppClients map[string]*github.Client
+	planDiffMaxSize int
+	// preflight holds the results of the preflight checks run when planning
+	// writes, or is nil if they are disabled.
+	preflight *preflightCache
+	readOnly  bool
+}
+
+// client returns the GitHub client to use for repositories of the given
+// owner, falling back to the default one.
+func (c *providerConfiguration) client(owner string) *github.Client {
+	if v, ok := c.ownerClients[strings.ToLower(owner)]; ok {
+		return v
+	}
+	return c.githubClient
+}
+
+// appsClient returns the client authenticating as the app whose installation
+// the client for the given owner authenticates as, or nil if it does not.
+func (c *providerConfiguration) appsClient(owner string) *github.Client {
+	k := strings.ToLower(owner)
+	if _, ok := c.ownerClients[k]; ok {
+		return c.ownerAppClients[k]
+	}
+	return c.githubAppsClient
 }
 
 type githubfileProvider struct{}
 
 type githubfileProviderModel struct {
-	CommitMessagePrefix types.String `tfsdk:"commit_message_prefix"`
-	GithubEmail         types.String `tfsdk:"github_email"`
-	GithubToken         types.String `tfsdk:"github_token"`
-	GithubUsername      types.String `tfsdk:"github_username"`
-	GpgPassphrase       types.String `tfsdk:"gpg_passphrase"`
-	GpgSecretKey        types.String `tfsdk:"gpg_secret_key"`
+	AppAuth             *appAuthModel               `tfsdk:"app_auth"`
+	BaseURL             types.String                `tfsdk:"base_url"`
+	BranchPrefix        types.String                `tfsdk:"branch_prefix"`
+	CABundleFile        types.String                `tfsdk:"ca_bundle_file"`
+	CommitMessagePrefix types.String                `tfsdk:"commit_message_prefix"`
+	Credentials         map[string]credentialsModel `tfsdk:"credentials"`
+	GithubEmail         types.String                `tfsdk:"github_email"`
+	GithubToken         types.String                `tfsdk:"github_token"`
+	GithubUsername      types.String                `tfsdk:"github_username"`
+	GpgPassphrase       types.String                `tfsdk:"gpg_passphrase"`
+	GpgSecretKey        types.String                `tfsdk:"gpg_secret_key"`
+	PlanDiffMaxSize     types.Int64                 `tfsdk:"plan_diff_max_size"`
+	Preflight           types.Bool                  `tfsdk:"preflight"`
+	ProxyURL            types.String                `tfsdk:"proxy_url"`
+	RateLimit           *rateLimitModel             `tfsdk:"rate_limit"`
+	ReadOnly            types.Bool                  `tfsdk:"read_only"`
+	Retry               *retryModel                 `tfsdk:"retry"`
+	UploadURL           types.String                `tfsdk:"upload_url"`
+}
+
+type appAuthModel struct {
+	ID             types.String `tfsdk:"id"`
+	InstallationID types.String `tfsdk:"installation_id"`
+	PemFile        types.String `tfsdk:"pem_file"`
+}
+
+type credentialsModel struct {
+	AppAuth *appAuthModel `tfsdk:"app_auth"`
+	Token   types.String  `tfsdk:"token"`
 }
 
 // New returns a new instance of the githubfile provider.
@@ -62,24 +130,68 @@ func (p *githubfileProvider) Metadata(_ context.Context, _ provider.MetadataRequ
 func (p *githubfileProvider) Schema(_ context.Context, _ provider.SchemaRequest, resp *provider.SchemaResponse) {
 	resp.Schema = schema.Schema{
 		Attributes: map[string]schema.Attribute{
+			"base_url": schema.StringAttribute{
+				Optional:    true,
+				Description: "The base URL of the GitHub API, for use with GitHub Enterprise Server (e.g. https://github.example.com/api/v3/). Defaults to github.com. Can also be set via the GITHUB_BASE_URL environment variable.",
+			},
+			"branch_prefix": schema.StringAttribute{
+				Optional:    true,
+				Description: "The prefix of the names of the working branches from which pull requests are opened. Defaults to \"terraform-provider-githubfile-\".",
+			},
+			"ca_bundle_file": schema.StringAttribute{
+				Optional:    true,
+				Description: "The path to a PEM-encoded bundle of CA certificates to trust in addition to the system ones. Can also be set via the GITHUB_CA_BUNDLE_FILE environment variable.",
+			},
 			"commit_message_prefix": schema.StringAttribute{
 				Optional:    true,
 				Description: "An optional prefix to be added to all commits created as a result of manipulating files. Can also be set via the COMMIT_MESSAGE_PREFIX environment variable.",
 			},
+			"credentials": schema.MapNestedAttribute{
+				Optional:    true,
+				Description: "Credentials to use for the repositories of specific owners, keyed by owner. Owners without an entry use \"github_token\" or \"app_auth\".",
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"app_auth": schema.SingleNestedAttribute{
+							Optional:    true,
+							Description: "Authenticate as a GitHub App installation for this owner.",
+							Attributes: map[string]schema.Attribute{
+								"id": schema.StringAttribute{
+									Required:    true,
+									Description: "The ID of the GitHub App.",
+								},
+								"installation_id": schema.StringAttribute{
+									Optional:    true,
+									Description: "The ID of the app's installation. If omitted, the installation is looked up from the owner.",
+								},
+								"pem_file": schema.StringAttribute{
+									Required:    true,
+									Sensitive:   true,
+									Description: "The path to the app's PEM-encoded private key.",
+								},
+							},
+						},
+						"token": schema.StringAttribute{
+							Optional:    true,
+							Sensitive:   true,
+							Description: "A GitHub authorisation token for this owner.",
+						},
+					},
+				},
+			},
 			"github_email": schema.StringAttribute{
 				Optional:    true,
 				Sensitive:   true,
-				Description: "The email address to use for commit messages. If a GPG key is provided, this must match the one which the key corresponds to. Can also be set via the GITHUB_EMAIL environment variable.",
+				Description: "The email address to use for commit messages. If a GPG key is provided, this must match the one which the key corresponds to. Defaults to the primary (or noreply) email address of the authenticated user, or that of the app's bot user when using \"app_auth\". Can also be set via the GITHUB_EMAIL environment variable.",
 			},
 			"github_token": schema.StringAttribute{
 				Optional:    true,
 				Sensitive:   true,
-				Description: "A GitHub authorisation token with permissions to manage CRUD files in the target repositories. Can also be set via the GITHUB_TOKEN environment variable.",
+				Description: "A GitHub authorisation token with permissions to manage CRUD files in the target repositories. Not required if \"app_auth\" is configured. Can also be set via the GITHUB_TOKEN environment variable.",
 			},
 			"github_username": schema.StringAttribute{
 				Optional:    true,
 				Sensitive:   true,
-				Description: "The username to use for commit messages. Can also be set via the GITHUB_USERNAME environment variable.",
+				Description: "The username to use for commit messages. Defaults to the login of the authenticated user, or that of the app's bot user when using \"app_auth\". Can also be set via the GITHUB_USERNAME environment variable.",
 			},
 			"gpg_passphrase": schema.StringAttribute{
 				Optional:    true,
@@ -91,6 +203,80 @@ func (p *githubfileProvider) Schema(_ context.Context, _ provider.SchemaRequest,
 				Sensitive:   true,
 				Description: "The GPG secret key to be use for commit signing. Can also be set via the GPG_SECRET_KEY environment variable.",
 			},
+			"plan_diff_max_size": schema.Int64Attribute{
+				Optional:    true,
+				Description: "The size, in bytes, beyond which the diffs of file contents shown as warnings in plans are truncated. Set to 0 to disable the diffs. Defaults to 8192.",
+			},
+			"preflight": schema.BoolAttribute{
+				Optional:    true,
+				Description: "Whether to check, when planning to create or update files, that their repositories exist, are not archived and can be pushed to, and that their branches exist and have no protection or rulesets stopping the provider's pull requests from being merged. Can also be set via the GITHUB_PREFLIGHT environment variable. Defaults to false.",
+			},
+			"proxy_url": schema.StringAttribute{
+				Optional:    true,
+				Description: "The URL of a proxy through which to send requests to GitHub. Defaults to the one given by the HTTPS_PROXY environment variable, if any.",
+			},
+			"read_only": schema.BoolAttribute{
+				Optional:    true,
+				Description: "Whether to reject any attempt to create, update or delete files, for workspaces that only read them. When set, no commit identity is required and \"github_token\" may be omitted to access public repositories anonymously. Can also be set via the GITHUB_READ_ONLY environment variable.",
+			},
+			"upload_url": schema.StringAttribute{
+				Optional:    true,
+				Description: "The upload URL of the GitHub API, for use with GitHub Enterprise Server. Defaults to one derived from \"base_url\". Can also be set via the GITHUB_UPLOAD_URL environment variable.",
+			},
+		},
+		Blocks: map[string]schema.Block{
+			"app_auth": schema.SingleNestedBlock{
+				Description: "Authenticate as a GitHub App installation instead of using \"github_token\".",
+				Attributes: map[string]schema.Attribute{
+					"id": schema.StringAttribute{
+						Optional:    true,
+						Description: "The ID of the GitHub App. Can also be set via the GITHUB_APP_ID environment variable.",
+					},
+					"installation_id": schema.StringAttribute{
+						Optional:    true,
+						Description: "The ID of the app's installation. If omitted, the installation is looked up from the owner of each repository. Can also be set via the GITHUB_APP_INSTALLATION_ID environment variable.",
+					},
+					"pem_file": schema.StringAttribute{
+						Optional:    true,
+						Sensitive:   true,
+						Description: "The path to the app's PEM-encoded private key. Can also be set via the GITHUB_APP_PEM_FILE environment variable.",
+					},
+				},
+			},
+			"rate_limit": schema.SingleNestedBlock{
+				Description: "How to handle GitHub's rate limits. Rate limited requests are retried once the limit resets, and write requests are spaced at least a second apart.",
+				Attributes: map[string]schema.Attribute{
+					"max_wait": schema.StringAttribute{
+						Optional:    true,
+						Description: "The longest to wait for a rate limit to reset before failing the request, as a duration (e.g. \"5m\"). Defaults to \"15m\".",
+					},
+					"write_concurrency": schema.Int64Attribute{
+						Optional:    true,
+						Description: "The maximum number of write requests to send to GitHub at once. Defaults to 1, as GitHub recommends.",
+					},
+				},
+			},
+			"retry": schema.SingleNestedBlock{
+				Description: "How to retry requests to GitHub that fail transiently, such as with server errors, or when merging a pull request GitHub has not finished checking. The wait between attempts doubles each time.",
+				Attributes: map[string]schema.Attribute{
+					"initial_backoff": schema.StringAttribute{
+						Optional:    true,
+						Description: "How long to wait before the first retry, as a duration (e.g. \"1s\"). Defaults to \"5s\".",
+					},
+					"jitter": schema.BoolAttribute{
+						Optional:    true,
+						Description: "Whether to wait a random time between half of the backoff and all of it, so that concurrent retries spread out. Defaults to true.",
+					},
+					"max_attempts": schema.Int64Attribute{
+						Optional:    true,
+						Description: "The maximum number of attempts at each request, including the first one. Defaults to 3.",
+					},
+					"max_backoff": schema.StringAttribute{
+						Optional:    true,
+						Description: "The longest to wait between attempts, as a duration. Defaults to \"1m\".",
+					},
+				},
+			},
 		},
 	}
 }
@@ -102,49 +288,126 @@ func (p *githubfileProvider) Configure(ctx context.Context, req provider.Configu
 		return
 	}
 
-	token := stringValueOrEnv(config.GithubToken, "GITHUB_TOKEN")
-	if token == "" {
-		resp.Diagnostics.AddError(
-			"Missing GitHub Token",
-			"github_token must be configured or the GITHUB_TOKEN environment variable must be set.",
-		)
+	ep := &endpoint{
+		baseURL:   stringValueOrEnv(config.BaseURL, "GITHUB_BASE_URL"),
+		uploadURL: stringValueOrEnv(config.UploadURL, "GITHUB_UPLOAD_URL"),
+	}
+	bt, err := newBaseTransport(stringValueOrEnv(config.CABundleFile, "GITHUB_CA_BUNDLE_FILE"), config.ProxyURL.ValueString())
+	if err != nil {
+		resp.Diagnostics.AddError("Invalid HTTP Configuration", err.Error())
+		return
+	}
+	rlt, err := newRateLimitTransportFromModel(config.RateLimit, bt)
+	if err != nil {
+		resp.Diagnostics.AddError("Invalid Rate Limit Configuration", err.Error())
+		return
+	}
+	rp, err := newRetryPolicy(config.Retry)
+	if err != nil {
+		resp.Diagnostics.AddError("Invalid Retry Configuration", err.Error())
 		return
 	}
+	rt := newRetryTransport(rlt, rp)
 
-	email := stringValueOrEnv(config.GithubEmail, "GITHUB_EMAIL")
-	if email == "" {
-		resp.Diagnostics.AddError(
-			"Missing GitHub Email",
-			"github_email must be configured or the GITHUB_EMAIL environment variable must be set.",
-		)
+	readOnly, err := boolValueOrEnv(config.ReadOnly, "GITHUB_READ_ONLY")
+	if err != nil {
+		resp.Diagnostics.AddError("Invalid Read-Only Setting", err.Error())
 		return
 	}
 
-	username := stringValueOrEnv(config.GithubUsername, "GITHUB_USERNAME")
-	if username == "" {
+	token := stringValueOrEnv(config.GithubToken, "GITHUB_TOKEN")
+	if token == "" && config.AppAuth == nil && len(config.Credentials) == 0 && !readOnly {
 		resp.Diagnostics.AddError(
-			"Missing GitHub Username",
-			"github_username must be configured or the GITHUB_USERNAME environment variable must be set.",
+			"Missing GitHub Token",
+			"github_token must be configured, the GITHUB_TOKEN environment variable must be set, or app_auth or credentials must be configured.",
 		)
 		return
 	}
 
-	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
-	tc := oauth2.NewClient(ctx, ts)
-	gc := github.NewClient(tc)
+	gc, apps, err := newAuthenticatedClient(token, config.AppAuth, ep, rt)
+	if err != nil {
+		resp.Diagnostics.AddError("Invalid GitHub Credentials", err.Error())
+		return
+	}
+
+	ownerClients := make(map[string]*github.Client, len(config.Credentials))
+	ownerAppClients := make(map[string]*github.Client, len(config.Credentials))
+	for owner, cr := range config.Credentials {
+		if (cr.Token.ValueString() == "") == (cr.AppAuth == nil) {
+			resp.Diagnostics.AddError(
+				"Invalid GitHub Credentials",
+				fmt.Sprintf("Exactly one of token or app_auth must be configured in the credentials for %q.", owner),
+			)
+			return
+		}
+		c, a, err := newAuthenticatedClient(cr.Token.ValueString(), cr.AppAuth, ep, rt)
+		if err != nil {
+			resp.Diagnostics.AddError("Invalid GitHub Credentials", fmt.Sprintf("Failed to configure the credentials for %q: %v", owner, err))
+			return
+		}
+		ownerClients[strings.ToLower(owner)] = c
+		if a != nil {
+			ownerAppClients[strings.ToLower(owner)] = a
+		}
+	}
 
 	sk := stringValueOrEnv(config.GpgSecretKey, "GPG_SECRET_KEY")
 	if v, err := base64.StdEncoding.DecodeString(sk); err == nil {
 		sk = string(v)
 	}
 
+	email := stringValueOrEnv(config.GithubEmail, "GITHUB_EMAIL")
+	if email != "" && sk != "" {
+		if err := validateGPGKeyEmail(sk, email); err != nil {
+			resp.Diagnostics.AddError("Invalid GitHub Email", err.Error())
+			return
+		}
+	}
+
+	username := stringValueOrEnv(config.GithubUsername, "GITHUB_USERNAME")
+
+	preflight, err := boolValueOrEnv(config.Preflight, "GITHUB_PREFLIGHT")
+	if err != nil {
+		resp.Diagnostics.AddError("Invalid Preflight Setting", err.Error())
+		return
+	}
+
+	branchPrefix := config.BranchPrefix.ValueString()
+	if branchPrefix == "" {
+		branchPrefix = defaultBranchPrefix
+	}
+
+	planDiffMaxSize := int64(defaultPlanDiffMaxSize)
+	if v := config.PlanDiffMaxSize; !v.IsNull() && !v.IsUnknown() {
+		planDiffMaxSize = v.ValueInt64()
+	}
+	if planDiffMaxSize < 0 {
+		resp.Diagnostics.AddError("Invalid Plan Diff Size", fmt.Sprintf("plan_diff_max_size must not be negative, got %d.", planDiffMaxSize))
+		return
+	}
+
 	providerConfig := &providerConfiguration{
+		branchPrefix:        branchPrefix,
 		commitMessagePrefix: stringValueOrEnv(config.CommitMessagePrefix, "COMMIT_MESSAGE_PREFIX"),
 		githubClient:        gc,
+		githubAppsClient:    apps,
+		ownerClients:        ownerClients,
+		ownerAppClients:     ownerAppClients,
 		githubEmail:         email,
 		githubUsername:      username,
 		gpgSecretKey:        sk,
 		gpgPassphrase:       stringValueOrEnv(config.GpgPassphrase, "GPG_PASSPHRASE"),
+		noreplyDomain:       ep.noreplyDomain(),
+		planDiffMaxSize:     int(planDiffMaxSize),
+		readOnly:            readOnly,
+	}
+	// The identity of each set of credentials is discovered the first time
+	// a commit is made with them.
+	if (email == "" || username == "") && !readOnly {
+		providerConfig.identities = newIdentityCache()
+	}
+	if preflight && !readOnly {
+		providerConfig.preflight = newPreflightCache()
 	}
 
 	resp.DataSourceData = providerConfig
@@ -153,12 +416,34 @@ func (p *githubfileProvider) Configure(ctx context.Context, req provider.Configu
 
 func (p *githubfileProvider) Resources(_ context.Context) []func() resource.Resource {
 	return []func() resource.Resource{
+		NewBranchCleanupResource,
+		NewBranchFileResource,
 		NewFileResource,
 	}
 }
 
 func (p *githubfileProvider) DataSources(_ context.Context) []func() datasource.DataSource {
-	return nil
+	return []func() datasource.DataSource{
+		NewFileDataSource,
+		NewFileHistoryDataSource,
+		NewRepositoriesDataSource,
+		NewTreeDataSource,
+	}
+}
+
+func boolValueOrEnv(v types.Bool, envKey string) (bool, error) {
+	if !v.IsNull() && !v.IsUnknown() {
+		return v.ValueBool(), nil
+	}
+	e := os.Getenv(envKey)
+	if e == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(e)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse the %s environment variable %q as a boolean: %v", envKey, e, err)
+	}
+	return b, nil
 }
 
 func stringValueOrEnv(v types.String, envKey string) string {
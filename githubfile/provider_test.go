@@ -18,6 +18,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/google/go-github/v54/github"
 	"github.com/hashicorp/terraform-plugin-framework/providerserver"
 	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
 )
@@ -26,11 +27,12 @@ var testAccProtoV6ProviderFactories = map[string]func() (tfprotov6.ProviderServe
 	"githubfile": providerserver.NewProtocol6WithError(New()),
 }
 
+// testAccPreCheck checks that acceptance tests can run. GITHUB_EMAIL and
+// GITHUB_USERNAME are optional, so that the commit identity is discovered
+// from the token when they are unset.
 func testAccPreCheck(t *testing.T) {
 	required := []string{
-		"GITHUB_EMAIL",
 		"GITHUB_TOKEN",
-		"GITHUB_USERNAME",
 	}
 	for _, req := range required {
 		if v := os.Getenv(req); v == "" {
@@ -45,3 +47,18 @@ func TestProvider(t *testing.T) {
 		t.Fatal("provider should not be nil")
 	}
 }
+
+func TestProviderConfigurationClient(t *testing.T) {
+	d := github.NewClient(nil)
+	o := github.NewClient(nil)
+	c := &providerConfiguration{
+		githubClient: d,
+		ownerClients: map[string]*github.Client{"other-org": o},
+	}
+	if c.client("Other-Org") != o {
+		t.Error("expected the owner's client to be used, ignoring case")
+	}
+	if c.client("test-org") != d {
+		t.Error("expected the default client to be used for owners without credentials")
+	}
+}
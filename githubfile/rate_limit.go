@@ -0,0 +1,306 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+const (
+	// defaultRateLimitMaxWait is the longest the provider waits for a rate
+	// limit to reset by default before giving up on a request.
+	defaultRateLimitMaxWait = 15 * time.Minute
+	// defaultWriteConcurrency is the number of write requests sent to GitHub
+	// at once by default, as GitHub recommends making them serially.
+	defaultWriteConcurrency = 1
+	// writeInterval is the time GitHub recommends leaving between write
+	// requests to avoid secondary rate limits.
+	writeInterval = time.Second
+	// secondaryRateLimitWait is how long to wait after hitting a secondary
+	// rate limit whose response does not say for how long, as GitHub
+	// recommends waiting at least a minute.
+	secondaryRateLimitWait = time.Minute
+	// minRateLimitWait is the least time waited before retrying a rate
+	// limited request, so that a reset already in the past does not retry
+	// it straight away.
+	minRateLimitWait = time.Second
+	// maxRateLimitAttempts is the number of times a request is sent before
+	// giving up on it if it keeps being rate limited.
+	maxRateLimitAttempts = 5
+)
+
+// rateLimitModel describes the "rate_limit" block of the provider.
+type rateLimitModel struct {
+	MaxWait          types.String `tfsdk:"max_wait"`
+	WriteConcurrency types.Int64  `tfsdk:"write_concurrency"`
+}
+
+// rateLimitTransport is an http.RoundTripper that waits for GitHub's primary
+// and secondary rate limits to reset and retries rate limited requests, and
+// throttles write requests to GitHub's recommended pace.
+type rateLimitTransport struct {
+	base    http.RoundTripper
+	maxWait time.Duration
+	// writes holds a token for each write request in flight.
+	writes chan struct{}
+	// sleep waits for the given duration, or until the context is done.
+	sleep func(ctx context.Context, d time.Duration) error
+
+	mu        sync.Mutex
+	nextWrite time.Time
+}
+
+// newRateLimitTransport returns a rateLimitTransport sending requests through
+// base, waiting at most maxWait for any rate limit to reset and sending at
+// most writeConcurrency write requests at once.
+func newRateLimitTransport(base http.RoundTripper, maxWait time.Duration, writeConcurrency int) *rateLimitTransport {
+	return &rateLimitTransport{
+		base:    base,
+		maxWait: maxWait,
+		writes:  make(chan struct{}, writeConcurrency),
+		sleep:   sleepContext,
+	}
+}
+
+// newRateLimitTransportFromModel returns a rateLimitTransport sending requests
+// through base, configured from the given "rate_limit" block, if any.
+func newRateLimitTransportFromModel(m *rateLimitModel, base http.RoundTripper) (*rateLimitTransport, error) {
+	maxWait := defaultRateLimitMaxWait
+	writeConcurrency := defaultWriteConcurrency
+	if m != nil {
+		if v := m.MaxWait.ValueString(); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %q as a duration: %v", v, err)
+			}
+			if d < 0 {
+				return nil, fmt.Errorf("max_wait must not be negative, got %q", v)
+			}
+			maxWait = d
+		}
+		if !m.WriteConcurrency.IsNull() && !m.WriteConcurrency.IsUnknown() {
+			if m.WriteConcurrency.ValueInt64() < 1 {
+				return nil, fmt.Errorf("write_concurrency must be at least 1, got %d", m.WriteConcurrency.ValueInt64())
+			}
+			writeConcurrency = int(m.WriteConcurrency.ValueInt64())
+		}
+	}
+	return newRateLimitTransport(base, maxWait, writeConcurrency), nil
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if isWriteMethod(req.Method) {
+		select {
+		case t.writes <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		defer func() { <-t.writes }()
+		if err := t.sleep(ctx, t.reserveWrite()); err != nil {
+			return nil, err
+		}
+	}
+
+	// The maximum wait bounds the total time spent waiting for a request,
+	// however many times it is rate limited.
+	var waited time.Duration
+	for attempt := 1; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		logRateLimit(ctx, req, resp)
+
+		d, limited := rateLimitWait(resp, time.Now())
+		if limited && d < minRateLimitWait {
+			d = minRateLimitWait
+		}
+		if waited+d > t.maxWait {
+			tflog.Warn(ctx, "GitHub rate limit resets later than the maximum wait, giving up", map[string]interface{}{
+				"method":   req.Method,
+				"path":     req.URL.Path,
+				"wait":     d.String(),
+				"waited":   waited.String(),
+				"max_wait": t.maxWait.String(),
+			})
+			return resp, nil
+		}
+		if !limited {
+			// A successful response may still have used up the budget, in
+			// which case the GitHub client refuses to send any further
+			// request until the reset, so wait for it here instead.
+			if d > 0 {
+				tflog.Info(ctx, "GitHub rate limit exhausted, waiting for it to reset", map[string]interface{}{
+					"wait": d.String(),
+				})
+				if err := t.sleep(ctx, d); err != nil {
+					resp.Body.Close()
+					return nil, err
+				}
+			}
+			return resp, nil
+		}
+		if attempt >= maxRateLimitAttempts {
+			tflog.Warn(ctx, "GitHub rate limit still hit after retrying, giving up", map[string]interface{}{
+				"method":   req.Method,
+				"path":     req.URL.Path,
+				"attempts": attempt,
+			})
+			return resp, nil
+		}
+		// Requests whose body cannot be read again cannot be retried.
+		if req.Body != nil && req.GetBody == nil {
+			return resp, nil
+		}
+
+		tflog.Warn(ctx, "GitHub rate limit hit, waiting before retrying", map[string]interface{}{
+			"method": req.Method,
+			"path":   req.URL.Path,
+			"wait":   d.String(),
+		})
+		io.Copy(io.Discard, resp.Body) //nolint:errcheck
+		resp.Body.Close()
+		if err := t.sleep(ctx, d); err != nil {
+			return nil, err
+		}
+		waited += d
+		if req.GetBody != nil {
+			b, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = b
+		}
+	}
+}
+
+// reserveWrite reserves the next slot for a write request, returning how
+// long to wait until it.
+func (t *rateLimitTransport) reserveWrite() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	at := t.nextWrite
+	if at.Before(now) {
+		at = now
+	}
+	t.nextWrite = at.Add(writeInterval)
+	return at.Sub(now)
+}
+
+// rateLimitWait returns how long to wait before sending another request
+// after the given response, and whether the response is a rate limit error
+// for which the request should be retried after waiting.
+func rateLimitWait(resp *http.Response, now time.Time) (time.Duration, bool) {
+	remaining := resp.Header.Get("X-RateLimit-Remaining")
+	reset := resetWait(resp, now)
+
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		if remaining == "0" {
+			return reset, false
+		}
+		return 0, false
+	}
+
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if s, err := strconv.Atoi(v); err == nil {
+			return time.Duration(s) * time.Second, true
+		}
+	}
+	if remaining == "0" && resp.Header.Get("X-RateLimit-Reset") != "" {
+		return reset, true
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return secondaryRateLimitWait, true
+	}
+	// Other 403 responses are only rate limit errors if they say so.
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	if err == nil && strings.Contains(strings.ToLower(string(b)), "rate limit") {
+		return secondaryRateLimitWait, true
+	}
+	return 0, false
+}
+
+// resetWait returns the time until the rate limit of the given response
+// resets, plus a second to allow for clock skew.
+func resetWait(resp *http.Response, now time.Time) time.Duration {
+	v, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return 0
+	}
+	d := time.Unix(v, 0).Sub(now)
+	if d < 0 {
+		return 0
+	}
+	return d + time.Second
+}
+
+// logRateLimit logs the remaining rate limit budget reported by the given
+// response, if any.
+func logRateLimit(ctx context.Context, req *http.Request, resp *http.Response) {
+	remaining := resp.Header.Get("X-RateLimit-Remaining")
+	if remaining == "" {
+		return
+	}
+	tflog.Debug(ctx, "GitHub rate limit", map[string]interface{}{
+		"method":    req.Method,
+		"path":      req.URL.Path,
+		"status":    resp.StatusCode,
+		"resource":  resp.Header.Get("X-RateLimit-Resource"),
+		"limit":     resp.Header.Get("X-RateLimit-Limit"),
+		"remaining": remaining,
+		"reset":     resp.Header.Get("X-RateLimit-Reset"),
+	})
+}
+
+// isWriteMethod returns whether requests with the given method modify
+// resources, and hence count towards GitHub's write limits.
+func isWriteMethod(m string) bool {
+	switch m {
+	case http.MethodPost, http.MethodPatch, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// sleepContext waits for the given duration, or until the context is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimitTransport_RetriesAfterSecondaryRateLimit(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if b, _ := io.ReadAll(r.Body); string(b) != `{"sha":"abc"}` {
+			t.Errorf("expected the request body to be sent on every attempt, got %q", b)
+		}
+		if requests == 1 {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"message":"You have exceeded a secondary rate limit."}`)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	var waits []time.Duration
+	rt := newRateLimitTransport(http.DefaultTransport, time.Minute, 1)
+	rt.sleep = func(_ context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+
+	req, _ := http.NewRequest(http.MethodPatch, server.URL+"/repos/o/r/git/refs/heads/main", strings.NewReader(`{"sha":"abc"}`))
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 requests, got %d", requests)
+	}
+	if len(waits) != 2 || waits[1] != 30*time.Second {
+		t.Errorf("expected to wait for the write slot then 30s, got %v", waits)
+	}
+}
+
+func TestRateLimitTransport_GivesUpAfterMaxWait(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	rt := newRateLimitTransport(http.DefaultTransport, time.Minute, 1)
+	rt.sleep = func(_ context.Context, d time.Duration) error {
+		t.Errorf("unexpected wait of %v", d)
+		return nil
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/repos/o/r", nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected status %d, got %d", http.StatusForbidden, resp.StatusCode)
+	}
+	if requests != 1 {
+		t.Errorf("expected 1 request, got %d", requests)
+	}
+}
+
+func TestRateLimitTransport_BoundsRetries(t *testing.T) {
+	tests := map[string]struct {
+		maxWait  time.Duration
+		header   func(h http.Header)
+		requests int
+		waits    []time.Duration
+	}{
+		// A secondary rate limit without Retry-After stops being retried
+		// once the waits add up to the maximum.
+		"total wait": {
+			maxWait:  150 * time.Second,
+			header:   func(h http.Header) {},
+			requests: 3,
+			waits:    []time.Duration{time.Minute, time.Minute},
+		},
+		// A reset already in the past is still waited for a little, and the
+		// request is only retried so many times.
+		"reset in the past": {
+			maxWait: time.Hour,
+			header: func(h http.Header) {
+				h.Set("X-RateLimit-Remaining", "0")
+				h.Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10))
+			},
+			requests: maxRateLimitAttempts,
+			waits:    []time.Duration{time.Second, time.Second, time.Second, time.Second},
+		},
+	}
+	for name, tt := range tests {
+		var requests int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			tt.header(w.Header())
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+
+		var waits []time.Duration
+		rt := newRateLimitTransport(http.DefaultTransport, tt.maxWait, 1)
+		rt.sleep = func(_ context.Context, d time.Duration) error {
+			waits = append(waits, d)
+			return nil
+		}
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/repos/o/r", nil)
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got: %v", name, err)
+		}
+		resp.Body.Close()
+		server.Close()
+		if resp.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("%s: expected status %d, got %d", name, http.StatusTooManyRequests, resp.StatusCode)
+		}
+		if requests != tt.requests {
+			t.Errorf("%s: expected %d requests, got %d", name, tt.requests, requests)
+		}
+		if !reflect.DeepEqual(waits, tt.waits) {
+			t.Errorf("%s: expected waits %v, got %v", name, tt.waits, waits)
+		}
+	}
+}
+
+func TestRateLimitWait(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tests := []struct {
+		name            string
+		status          int
+		headers         map[string]string
+		body            string
+		expectedWait    time.Duration
+		expectedLimited bool
+	}{
+		{
+			name:   "ok",
+			status: http.StatusOK,
+			headers: map[string]string{
+				"X-RateLimit-Remaining": "10",
+				"X-RateLimit-Reset":     "1060",
+			},
+		},
+		{
+			name:   "ok with exhausted budget",
+			status: http.StatusOK,
+			headers: map[string]string{
+				"X-RateLimit-Remaining": "0",
+				"X-RateLimit-Reset":     "1060",
+			},
+			expectedWait: 61 * time.Second,
+		},
+		{
+			name:   "primary rate limit",
+			status: http.StatusForbidden,
+			headers: map[string]string{
+				"X-RateLimit-Remaining": "0",
+				"X-RateLimit-Reset":     "1060",
+			},
+			expectedWait:    61 * time.Second,
+			expectedLimited: true,
+		},
+		{
+			name:            "secondary rate limit without headers",
+			status:          http.StatusForbidden,
+			body:            `{"message":"You have exceeded a secondary rate limit."}`,
+			expectedWait:    time.Minute,
+			expectedLimited: true,
+		},
+		{
+			name:            "too many requests",
+			status:          http.StatusTooManyRequests,
+			expectedWait:    time.Minute,
+			expectedLimited: true,
+		},
+		{
+			name:   "forbidden",
+			status: http.StatusForbidden,
+			body:   `{"message":"Resource not accessible by integration"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode: tt.status,
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader(tt.body)),
+			}
+			for k, v := range tt.headers {
+				resp.Header.Set(k, v)
+			}
+			w, l := rateLimitWait(resp, now)
+			if w != tt.expectedWait || l != tt.expectedLimited {
+				t.Errorf("expected (%v, %t), got (%v, %t)", tt.expectedWait, tt.expectedLimited, w, l)
+			}
+			if b, _ := io.ReadAll(resp.Body); string(b) != tt.body {
+				t.Errorf("expected the body to be preserved, got %q", b)
+			}
+		})
+	}
+}
+
+func TestRateLimitTransport_SpacesWrites(t *testing.T) {
+	rt := newRateLimitTransport(http.DefaultTransport, time.Minute, 1)
+	if d := rt.reserveWrite(); d != 0 {
+		t.Errorf("expected the first write not to wait, got %v", d)
+	}
+	if d := rt.reserveWrite(); d <= writeInterval-100*time.Millisecond || d > writeInterval {
+		t.Errorf("expected the second write to wait about %v, got %v", writeInterval, d)
+	}
+}
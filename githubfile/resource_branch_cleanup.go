@@ -0,0 +1,304 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v54/github"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// defaultBranchCleanupMaxAge is how long working branches are left alone by
+// default before being swept.
+const defaultBranchCleanupMaxAge = 24 * time.Hour
+
+var (
+	_ resource.Resource               = &branchCleanupResource{}
+	_ resource.ResourceWithConfigure  = &branchCleanupResource{}
+	_ resource.ResourceWithModifyPlan = &branchCleanupResource{}
+)
+
+type branchCleanupResource struct {
+	config *providerConfiguration
+}
+
+type branchCleanupResourceModel struct {
+	ID              types.String `tfsdk:"id"`
+	RepositoryOwner types.String `tfsdk:"repository_owner"`
+	RepositoryName  types.String `tfsdk:"repository_name"`
+	Prefix          types.String `tfsdk:"prefix"`
+	MaxAge          types.String `tfsdk:"max_age"`
+	Triggers        types.Map    `tfsdk:"triggers"`
+	DeletedBranches types.List   `tfsdk:"deleted_branches"`
+}
+
+// NewBranchCleanupResource returns a new branch cleanup resource.
+func NewBranchCleanupResource() resource.Resource {
+	return &branchCleanupResource{}
+}
+
+func (r *branchCleanupResource) Metadata(_ context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_branch_cleanup"
+}
+
+func (r *branchCleanupResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: "Sweeps stale working branches left behind by pull requests that were never merged whenever it is created or updated, closing their pull requests.",
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				Computed:    true,
+				Description: "The ID of the branch cleanup (format: owner/repo).",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+			"repository_owner": schema.StringAttribute{
+				Required:    true,
+				Description: "The owner of the repository to sweep.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
+				Validators: []validator.String{
+					ownerValidator(),
+				},
+			},
+			"repository_name": schema.StringAttribute{
+				Required:    true,
+				Description: "The name of the repository to sweep.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
+				Validators: []validator.String{
+					repositoryNameValidator(),
+				},
+			},
+			"prefix": schema.StringAttribute{
+				Optional:    true,
+				Description: "The prefix of the names of the branches to sweep. Defaults to the provider's \"branch_prefix\".",
+			},
+			"max_age": schema.StringAttribute{
+				Optional:    true,
+				Description: "How long since a branch's last commit, or the last update to its pull requests, before it is swept, as a duration (e.g. \"72h\"). Defaults to \"24h\".",
+				Validators: []validator.String{
+					positiveDurationValidator(),
+				},
+			},
+			"triggers": schema.MapAttribute{
+				Optional:    true,
+				ElementType: types.StringType,
+				Description: "Arbitrary values whose change causes a sweep, e.g. { always = timestamp() } to sweep on every apply.",
+			},
+			"deleted_branches": schema.ListAttribute{
+				Computed:    true,
+				ElementType: types.StringType,
+				Description: "The names of the branches deleted by the last sweep.",
+			},
+		},
+	}
+}
+
+func (r *branchCleanupResource) Configure(_ context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+	config, ok := req.ProviderData.(*providerConfiguration)
+	if !ok {
+		resp.Diagnostics.AddError(
+			"Unexpected Provider Data",
+			fmt.Sprintf("Expected *providerConfiguration, got: %T", req.ProviderData),
+		)
+		return
+	}
+	r.config = config
+}
+
+// ModifyPlan rejects sweeps, and any other change, if the provider is
+// read-only.
+func (r *branchCleanupResource) ModifyPlan(_ context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	rejectReadOnlyChange(r.config, req, resp, "branch cleanup")
+}
+
+func (r *branchCleanupResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var plan branchCleanupResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(r.sweep(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+func (r *branchCleanupResource) Read(_ context.Context, _ resource.ReadRequest, _ *resource.ReadResponse) {
+	// There is nothing to read back, as the resource only exists in state.
+}
+
+func (r *branchCleanupResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	var plan branchCleanupResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(r.sweep(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+func (r *branchCleanupResource) Delete(_ context.Context, _ resource.DeleteRequest, _ *resource.DeleteResponse) {
+	// Deleting the resource leaves the repository untouched.
+}
+
+// sweep sweeps the branches described by the given model, recording the
+// ones deleted in it.
+func (r *branchCleanupResource) sweep(ctx context.Context, m *branchCleanupResourceModel) diag.Diagnostics {
+	var diags diag.Diagnostics
+	maxAge := defaultBranchCleanupMaxAge
+	if v := m.MaxAge.ValueString(); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			diags.AddError("Invalid max_age", fmt.Sprintf("Failed to parse %q as a duration: %v", v, err))
+			return diags
+		}
+		maxAge = d
+	}
+	prefix := m.Prefix.ValueString()
+	if prefix == "" {
+		prefix = r.config.branchPrefix
+	}
+
+	owner, repo := m.RepositoryOwner.ValueString(), m.RepositoryName.ValueString()
+	deleted, err := sweepBranches(ctx, r.config, owner, repo, prefix, maxAge, time.Now())
+	if err != nil {
+		diags.AddError("Failed to sweep branches", err.Error())
+		return diags
+	}
+
+	v, d := types.ListValueFrom(ctx, types.StringType, deleted)
+	diags.Append(d...)
+	m.ID = types.StringValue(fmt.Sprintf("%s/%s", owner, repo))
+	m.DeletedBranches = v
+	return diags
+}
+
+// --- Business logic functions (testable independently) ---
+
+// sweepBranches deletes the branches of the given repository whose names
+// start with the given prefix, and which have seen neither a commit nor an
+// update to their pull requests within maxAge of now, closing any of their
+// pull requests still open. It returns the names of the deleted branches.
+func sweepBranches(ctx context.Context, c *providerConfiguration, owner, repo, prefix string, maxAge time.Duration, now time.Time) ([]string, error) {
+	if c.readOnly {
+		return nil, errReadOnly
+	}
+	// Sweeping without a prefix would delete every stale branch, which is
+	// never what is wanted.
+	if prefix == "" {
+		return nil, errors.New("the prefix of the branches to sweep must not be empty")
+	}
+	// Nor would sweeping without a positive age, which could delete the
+	// working branch of a write in flight.
+	if maxAge <= 0 {
+		return nil, fmt.Errorf("the age of the branches to sweep must be positive, got %v", maxAge)
+	}
+	gc := c.client(owner)
+
+	var refs []*github.Reference
+	o := &github.ReferenceListOptions{
+		Ref:         "heads/" + prefix,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		v, res, err := gc.Git.ListMatchingRefs(ctx, owner, repo, o)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list branches of %s/%s starting with %q: %v", owner, repo, prefix, err)
+		}
+		refs = append(refs, v...)
+		if res.NextPage == 0 {
+			break
+		}
+		o.Page = res.NextPage
+	}
+
+	deleted := []string{}
+	for _, ref := range refs {
+		b := strings.TrimPrefix(ref.GetRef(), "refs/heads/")
+		cm, _, err := gc.Git.GetCommit(ctx, owner, repo, ref.GetObject().GetSHA())
+		if err != nil {
+			return deleted, fmt.Errorf("failed to retrieve the last commit of branch %q: %v", b, err)
+		}
+		last := cm.GetCommitter().GetDate().Time
+		prs, _, err := gc.PullRequests.List(ctx, owner, repo, &github.PullRequestListOptions{
+			Head:  owner + ":" + b,
+			State: "all",
+		})
+		if err != nil {
+			return deleted, fmt.Errorf("failed to list pull requests from branch %q: %v", b, err)
+		}
+		for _, pr := range prs {
+			if t := pr.GetUpdatedAt().Time; t.After(last) {
+				last = t
+			}
+		}
+		if now.Sub(last) < maxAge || holdsOpenConflict(b, prs) {
+			continue
+		}
+
+		for _, pr := range prs {
+			if pr.GetState() != "open" {
+				continue
+			}
+			if _, _, err := gc.PullRequests.Edit(ctx, owner, repo, pr.GetNumber(), &github.PullRequest{State: github.String("closed")}); err != nil {
+				return deleted, fmt.Errorf("failed to close pull request #%d: %v", pr.GetNumber(), err)
+			}
+		}
+		if _, err := gc.Git.DeleteRef(ctx, owner, repo, "heads/"+b); err != nil {
+			return deleted, fmt.Errorf("failed to delete branch %q: %v", b, err)
+		}
+		deleted = append(deleted, b)
+	}
+	return deleted, nil
+}
+
+// holdsOpenConflict returns whether the given branch holds unresolved
+// conflicts through one of its pull requests still open, however long it has
+// been idle.
+func holdsOpenConflict(b string, prs []*github.PullRequest) bool {
+	if !conflictBranchRegexp.MatchString(b) {
+		return false
+	}
+	for _, pr := range prs {
+		if pr.GetState() == "open" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestSweepBranches(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	var closed []string
+	var deletedRefs []string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo/git/matching-refs/heads/tf-", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[
+			{"ref":"refs/heads/tf-stale","object":{"sha":"old"}},
+			{"ref":"refs/heads/tf-recent-pr","object":{"sha":"old"}},
+			{"ref":"refs/heads/tf-fresh","object":{"sha":"new"}},
+			{"ref":"refs/heads/tf-conflict-0123456789abcdef","object":{"sha":"old"}},
+			{"ref":"refs/heads/tf-conflict-fedcba9876543210","object":{"sha":"old"}}
+		]`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/git/commits/old", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"sha":"old","committer":{"date":"2024-05-01T00:00:00Z"}}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/git/commits/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"sha":"new","committer":{"date":"2024-05-31T12:00:00Z"}}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/pulls", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("head") {
+		case "test-owner:tf-stale":
+			fmt.Fprint(w, `[{"number":1,"state":"open","updated_at":"2024-05-01T00:00:00Z"},{"number":2,"state":"closed","updated_at":"2024-05-02T00:00:00Z"}]`)
+		case "test-owner:tf-recent-pr":
+			fmt.Fprint(w, `[{"number":3,"state":"open","updated_at":"2024-05-31T18:00:00Z"}]`)
+		case "test-owner:tf-conflict-0123456789abcdef":
+			fmt.Fprint(w, `[{"number":4,"state":"open","updated_at":"2024-05-01T00:00:00Z"}]`)
+		case "test-owner:tf-conflict-fedcba9876543210":
+			fmt.Fprint(w, `[{"number":5,"state":"closed","updated_at":"2024-05-01T00:00:00Z"}]`)
+		default:
+			fmt.Fprint(w, `[]`)
+		}
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/pulls/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPatch {
+			closed = append(closed, r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/git/refs/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			deletedRefs = append(deletedRefs, r.URL.Path)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{
+		githubClient: newMockGitHubClient(server),
+	}
+
+	deleted, err := sweepBranches(context.Background(), config, "test-owner", "test-repo", "tf-", 24*time.Hour, now)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if expected := []string{"tf-stale", "tf-conflict-fedcba9876543210"}; !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("expected deleted branches %v, got %v", expected, deleted)
+	}
+	if expected := []string{"/repos/test-owner/test-repo/pulls/1"}; !reflect.DeepEqual(closed, expected) {
+		t.Errorf("expected closed pull requests %v, got %v", expected, closed)
+	}
+	expected := []string{
+		"/repos/test-owner/test-repo/git/refs/heads/tf-stale",
+		"/repos/test-owner/test-repo/git/refs/heads/tf-conflict-fedcba9876543210",
+	}
+	if !reflect.DeepEqual(deletedRefs, expected) {
+		t.Errorf("expected deleted refs %v, got %v", expected, deletedRefs)
+	}
+}
+
+func TestSweepBranches_EmptyPrefix(t *testing.T) {
+	if _, err := sweepBranches(context.Background(), &providerConfiguration{}, "test-owner", "test-repo", "", time.Hour, time.Now()); err == nil {
+		t.Error("expected an empty prefix to be rejected")
+	}
+}
+
+func TestSweepBranches_NonPositiveMaxAge(t *testing.T) {
+	for _, v := range []time.Duration{0, -time.Hour} {
+		if _, err := sweepBranches(context.Background(), &providerConfiguration{}, "test-owner", "test-repo", "tf-", v, time.Now()); err == nil {
+			t.Errorf("expected a max age of %v to be rejected", v)
+		}
+	}
+}
+
+func TestBranchCleanup_ReadOnly(t *testing.T) {
+	ctx := context.Background()
+	r := &branchCleanupResource{config: &providerConfiguration{readOnly: true}}
+	var sr resource.SchemaResponse
+	r.Schema(ctx, resource.SchemaRequest{}, &sr)
+	null := tftypes.NewValue(sr.Schema.Type().TerraformType(ctx), nil)
+
+	plan := tfsdk.Plan{Schema: sr.Schema, Raw: null}
+	m := branchCleanupResourceModel{
+		ID:              types.StringUnknown(),
+		RepositoryOwner: types.StringValue("test-owner"),
+		RepositoryName:  types.StringValue("test-repo"),
+		Prefix:          types.StringNull(),
+		MaxAge:          types.StringNull(),
+		Triggers:        types.MapNull(types.StringType),
+		DeletedBranches: types.ListUnknown(types.StringType),
+	}
+	if diags := plan.Set(ctx, &m); diags.HasError() {
+		t.Fatalf("failed to build plan: %v", diags)
+	}
+
+	tests := map[string]resource.ModifyPlanRequest{
+		"create": {Plan: plan, State: tfsdk.State{Schema: sr.Schema, Raw: null}},
+		"delete": {Plan: tfsdk.Plan{Schema: sr.Schema, Raw: null}, State: tfsdk.State{Schema: sr.Schema, Raw: plan.Raw}},
+	}
+	for name, req := range tests {
+		resp := &resource.ModifyPlanResponse{Plan: req.Plan}
+		r.ModifyPlan(ctx, req, resp)
+		if !resp.Diagnostics.HasError() || resp.Diagnostics.Errors()[0].Summary() != "Provider Is Read-Only" {
+			t.Errorf("%s: expected the plan to be rejected as read-only, got: %v", name, resp.Diagnostics)
+		}
+	}
+
+	// Planning no change is fine.
+	req := resource.ModifyPlanRequest{Plan: plan, State: tfsdk.State{Schema: sr.Schema, Raw: plan.Raw}}
+	resp := &resource.ModifyPlanResponse{Plan: req.Plan}
+	r.ModifyPlan(ctx, req, resp)
+	if resp.Diagnostics.HasError() {
+		t.Errorf("expected no change to be accepted, got: %v", resp.Diagnostics)
+	}
+}
@@ -0,0 +1,663 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	pathpkg "path"
+	"sort"
+	"strings"
+
+	ghfileutils "github.com/form3tech-oss/go-github-utils/pkg/file"
+	"github.com/google/go-github/v54/github"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+var (
+	_ resource.Resource                   = &branchFileResource{}
+	_ resource.ResourceWithConfigure      = &branchFileResource{}
+	_ resource.ResourceWithModifyPlan     = &branchFileResource{}
+	_ resource.ResourceWithValidateConfig = &branchFileResource{}
+)
+
+// branchStatusType is the type of the status of the file in each branch.
+var branchStatusType = types.ObjectType{AttrTypes: map[string]attr.Type{
+	"blob_sha": types.StringType,
+	"in_sync":  types.BoolType,
+}}
+
+type branchFileResource struct {
+	config *providerConfiguration
+}
+
+type branchFileResourceModel struct {
+	ID              types.String `tfsdk:"id"`
+	RepositoryOwner types.String `tfsdk:"repository_owner"`
+	RepositoryName  types.String `tfsdk:"repository_name"`
+	Branches        types.List   `tfsdk:"branches"`
+	BranchPattern   types.String `tfsdk:"branch_pattern"`
+	Path            types.String `tfsdk:"path"`
+	Contents        types.String `tfsdk:"contents"`
+	BranchStatus    types.Map    `tfsdk:"branch_status"`
+}
+
+type branchStatusModel struct {
+	BlobSHA types.String `tfsdk:"blob_sha"`
+	InSync  types.Bool   `tfsdk:"in_sync"`
+}
+
+// NewBranchFileResource returns a new branch file resource.
+func NewBranchFileResource() resource.Resource {
+	return &branchFileResource{}
+}
+
+func (r *branchFileResource) Metadata(_ context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_branch_file"
+}
+
+func (r *branchFileResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: "Keeps a file identical in each of a list of branches, or in every branch matching a pattern, picking up new matching branches on refresh.",
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				Computed:    true,
+				Description: "The ID of the branch file (format: owner/repo:path).",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+			"repository_owner": schema.StringAttribute{
+				Required:    true,
+				Description: "The owner of the repository.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
+				Validators: []validator.String{
+					ownerValidator(),
+				},
+			},
+			"repository_name": schema.StringAttribute{
+				Required:    true,
+				Description: "The name of the repository.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
+				Validators: []validator.String{
+					repositoryNameValidator(),
+				},
+			},
+			"branches": schema.ListAttribute{
+				Optional:    true,
+				ElementType: types.StringType,
+				Description: "The branches to keep the file in. Exactly one of \"branches\" and \"branch_pattern\" must be set.",
+			},
+			"branch_pattern": schema.StringAttribute{
+				Optional:    true,
+				Description: "A glob matching the branches to keep the file in (e.g. \"release/*\"), where \"**\" matches any number of path segments. The provider's working branches are never matched.",
+			},
+			"path": schema.StringAttribute{
+				Required:    true,
+				Description: "The path of the file.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
+				Validators: []validator.String{
+					filePathValidator(),
+				},
+			},
+			"contents": schema.StringAttribute{
+				Required:    true,
+				Description: "The contents of the file in every branch.",
+			},
+			"branch_status": schema.MapNestedAttribute{
+				Computed:    true,
+				Description: "The status of the file in each branch, keyed by branch.",
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"blob_sha": schema.StringAttribute{
+							Computed:    true,
+							Description: "The blob SHA of the file in the branch, or empty if it does not exist there.",
+						},
+						"in_sync": schema.BoolAttribute{
+							Computed:    true,
+							Description: "Whether the file in the branch has the configured contents.",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (r *branchFileResource) Configure(_ context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+	config, ok := req.ProviderData.(*providerConfiguration)
+	if !ok {
+		resp.Diagnostics.AddError(
+			"Unexpected Provider Data",
+			fmt.Sprintf("Expected *providerConfiguration, got: %T", req.ProviderData),
+		)
+		return
+	}
+	r.config = config
+}
+
+func (r *branchFileResource) ValidateConfig(ctx context.Context, req resource.ValidateConfigRequest, resp *resource.ValidateConfigResponse) {
+	var config branchFileResourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &config)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if !config.Branches.IsUnknown() && !config.BranchPattern.IsUnknown() && config.Branches.IsNull() == config.BranchPattern.IsNull() {
+		resp.Diagnostics.AddAttributeError(
+			path.Root("branches"),
+			"Invalid Branch Selection",
+			"Exactly one of branches and branch_pattern must be set.",
+		)
+	}
+	if !config.Branches.IsNull() && !config.Branches.IsUnknown() {
+		for i, v := range config.Branches.Elements() {
+			s, ok := v.(types.String)
+			if !ok || s.IsNull() || s.IsUnknown() {
+				continue
+			}
+			if err := validateBranch(s.ValueString()); err != nil {
+				resp.Diagnostics.AddAttributeError(path.Root("branches").AtListIndex(i), "Invalid Branch", err.Error())
+			}
+		}
+	}
+	if v := config.BranchPattern; !v.IsNull() && !v.IsUnknown() {
+		if err := validateBranchPattern(v.ValueString()); err != nil {
+			resp.Diagnostics.AddAttributeError(path.Root("branch_pattern"), "Invalid Branch Pattern", err.Error())
+		}
+	}
+}
+
+// ModifyPlan plans an update of the file if it is out of sync in any of its
+// branches, and rejects any change if the provider is read-only.
+func (r *branchFileResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	if req.Plan.Raw.IsNull() || req.State.Raw.IsNull() {
+		rejectReadOnlyChange(r.config, req, resp, "branch file")
+		return
+	}
+	var state branchFileResourceModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	// A branch found out of sync on refresh, e.g. a new matching branch,
+	// needs an update even though the configuration is unchanged.
+	statuses, diags := statusesFromModel(ctx, &state)
+	resp.Diagnostics.Append(diags...)
+	for _, s := range statuses {
+		if !s.inSync {
+			resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root("branch_status"), types.MapUnknown(branchStatusType))...)
+			break
+		}
+	}
+	rejectReadOnlyChange(r.config, req, resp, "branch file")
+}
+
+func (r *branchFileResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var plan branchFileResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	pending := map[string]*pendingWrite{}
+	diags, err := r.write(ctx, &plan, pending)
+	resp.Diagnostics.Append(diags...)
+	resp.Diagnostics.Append(setPendingWrites(ctx, resp.Private, pending)...)
+	if plan.BranchStatus.IsUnknown() {
+		return
+	}
+	// Failing to write to some branches is only a warning, as an error would
+	// taint the resource, and its replacement would delete the file from
+	// every branch. The branches written to are recorded, and the others out
+	// of sync, so that only those are written to on the next apply.
+	if err != nil {
+		resp.Diagnostics.AddWarning("Failed to write file to some branches", err.Error())
+	}
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+func (r *branchFileResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	var state branchFileResourceModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(r.read(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+}
+
+func (r *branchFileResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	var plan, state branchFileResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	pending, diags := getPendingWrites(ctx, req.Private)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	// The branches written to before a failure are recorded, so that only
+	// the others are written to on the next apply.
+	diags, err := r.write(ctx, &plan, pending)
+	resp.Diagnostics.Append(diags...)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to write file", err.Error())
+	}
+	if !plan.BranchStatus.IsUnknown() {
+		resp.Diagnostics.Append(r.deleteRemoved(ctx, &state, &plan, pending)...)
+		resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+	}
+	resp.Diagnostics.Append(setPendingWrites(ctx, resp.Private, pending)...)
+}
+
+func (r *branchFileResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	var state branchFileResourceModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	statuses, diags := statusesFromModel(ctx, &state)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	pending, diags := getPendingWrites(ctx, req.Private)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	var branches []string
+	for _, s := range statuses {
+		branches = append(branches, s.branch)
+	}
+	_, err := deleteBranchFiles(ctx, r.config, state.RepositoryOwner.ValueString(), state.RepositoryName.ValueString(), state.Path.ValueString(), branches, pending)
+	resp.Diagnostics.Append(setPendingWrites(ctx, resp.Private, pending)...)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to delete file", err.Error())
+	}
+}
+
+// deleteRemoved deletes the file from the branches recorded in the prior
+// state which are no longer among those of the given model, e.g. because they
+// were removed from its list or stopped matching its pattern. The branches it
+// could not be deleted from are kept in the model, out of sync, so that the
+// next apply tries again. The pending writes of each branch are resumed or
+// cleaned up, and updated, as for deleteBranchFiles.
+func (r *branchFileResource) deleteRemoved(ctx context.Context, prior, m *branchFileResourceModel, pending map[string]*pendingWrite) diag.Diagnostics {
+	var diags diag.Diagnostics
+	statuses, d := statusesFromModel(ctx, m)
+	diags.Append(d...)
+	priorStatuses, d := statusesFromModel(ctx, prior)
+	diags.Append(d...)
+	if diags.HasError() {
+		return diags
+	}
+	removed := map[string]branchFileStatus{}
+	var branches []string
+	for _, s := range removedBranches(priorStatuses, statuses) {
+		removed[s.branch] = s
+		branches = append(branches, s.branch)
+	}
+	failed, err := deleteBranchFiles(ctx, r.config, m.RepositoryOwner.ValueString(), m.RepositoryName.ValueString(), m.Path.ValueString(), branches, pending)
+	if err != nil {
+		diags.AddError("Failed to delete file", err.Error())
+	}
+	for _, b := range failed {
+		s := removed[b]
+		s.inSync = false
+		statuses = append(statuses, s)
+	}
+	diags.Append(setStatuses(ctx, m, statuses)...)
+	return diags
+}
+
+// write writes the file described by the given model to each of its
+// branches, recording their status in it, and updating the given pending
+// writes of each branch. If some branches could not be written to, the status
+// of the others is recorded all the same, and the failures to write to them
+// are returned.
+func (r *branchFileResource) write(ctx context.Context, m *branchFileResourceModel, pending map[string]*pendingWrite) (diag.Diagnostics, error) {
+	var diags diag.Diagnostics
+	branches, d := r.branches(ctx, m)
+	diags.Append(d...)
+	if diags.HasError() {
+		return diags, nil
+	}
+	owner, repo, p := m.RepositoryOwner.ValueString(), m.RepositoryName.ValueString(), m.Path.ValueString()
+	statuses, err := readBranchFiles(ctx, r.config, owner, repo, p, m.Contents.ValueString(), branches)
+	if err != nil {
+		diags.AddError("Failed to read file", err.Error())
+		return diags, nil
+	}
+	statuses, err = writeBranchFiles(ctx, r.config, owner, repo, p, m.Contents.ValueString(), statuses, pending)
+	diags.Append(setStatuses(ctx, m, statuses)...)
+	return diags, err
+}
+
+// read refreshes the status of the file described by the given model in each
+// of its branches, including any which have started matching its pattern.
+// The branches which have stopped being among them keep the file until it is
+// deleted from them on the next apply, so they are kept, out of sync.
+func (r *branchFileResource) read(ctx context.Context, m *branchFileResourceModel) diag.Diagnostics {
+	prior, diags := statusesFromModel(ctx, m)
+	branches, d := r.branches(ctx, m)
+	diags.Append(d...)
+	if diags.HasError() {
+		return diags
+	}
+	statuses, err := readBranchFiles(ctx, r.config, m.RepositoryOwner.ValueString(), m.RepositoryName.ValueString(), m.Path.ValueString(), m.Contents.ValueString(), branches)
+	if err != nil {
+		diags.AddError("Failed to read file", err.Error())
+		return diags
+	}
+	for _, s := range removedBranches(prior, statuses) {
+		s.inSync = false
+		statuses = append(statuses, s)
+	}
+	diags.Append(setStatuses(ctx, m, statuses)...)
+	return diags
+}
+
+// setStatuses records the given statuses of the file in the given model.
+func setStatuses(ctx context.Context, m *branchFileResourceModel, statuses []branchFileStatus) diag.Diagnostics {
+	elems := map[string]branchStatusModel{}
+	for _, s := range statuses {
+		elems[s.branch] = branchStatusModel{
+			BlobSHA: types.StringValue(s.blobSHA),
+			InSync:  types.BoolValue(s.inSync),
+		}
+	}
+	v, diags := types.MapValueFrom(ctx, branchStatusType, elems)
+	m.ID = types.StringValue(fmt.Sprintf("%s/%s:%s", m.RepositoryOwner.ValueString(), m.RepositoryName.ValueString(), m.Path.ValueString()))
+	m.BranchStatus = v
+	return diags
+}
+
+// branches returns the branches of the file described by the given model,
+// listing those matching its pattern if it has one.
+func (r *branchFileResource) branches(ctx context.Context, m *branchFileResourceModel) ([]string, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	if !m.BranchPattern.IsNull() {
+		v, err := listMatchingBranches(ctx, r.config, m.RepositoryOwner.ValueString(), m.RepositoryName.ValueString(), m.BranchPattern.ValueString())
+		if err != nil {
+			diags.AddError("Failed to list branches", err.Error())
+		}
+		return v, diags
+	}
+	var v []string
+	diags.Append(m.Branches.ElementsAs(ctx, &v, false)...)
+	return v, diags
+}
+
+// statusesFromModel returns the status of the file in each branch recorded
+// in the given model, sorted by branch.
+func statusesFromModel(ctx context.Context, m *branchFileResourceModel) ([]branchFileStatus, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	if m.BranchStatus.IsNull() || m.BranchStatus.IsUnknown() {
+		return nil, diags
+	}
+	elems := map[string]branchStatusModel{}
+	diags.Append(m.BranchStatus.ElementsAs(ctx, &elems, false)...)
+	statuses := make([]branchFileStatus, 0, len(elems))
+	for b, v := range elems {
+		statuses = append(statuses, branchFileStatus{
+			branch:  b,
+			blobSHA: v.BlobSHA.ValueString(),
+			inSync:  v.InSync.ValueBool(),
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].branch < statuses[j].branch })
+	return statuses, diags
+}
+
+// pendingWritesKey is the key of the private state holding the pending
+// writes of a branch file, by branch.
+const pendingWritesKey = "pending_writes"
+
+// getPendingWrites returns the pending writes recorded in the given private
+// state, by branch, as getPendingWrite does for a single file.
+func getPendingWrites(ctx context.Context, p privateStateGetter) (map[string]*pendingWrite, diag.Diagnostics) {
+	w := map[string]*pendingWrite{}
+	v, diags := p.GetKey(ctx, pendingWritesKey)
+	if diags.HasError() || len(v) == 0 {
+		return w, diags
+	}
+	if err := json.Unmarshal(v, &w); err != nil {
+		diags.AddError("Invalid private state", fmt.Sprintf("Failed to parse the pending writes %q: %v", v, err))
+	}
+	return w, diags
+}
+
+// setPendingWrites records the given pending writes in the given private
+// state, or removes them if there are none.
+func setPendingWrites(ctx context.Context, p privateStateSetter, w map[string]*pendingWrite) diag.Diagnostics {
+	if len(w) == 0 {
+		return p.SetKey(ctx, pendingWritesKey, nil)
+	}
+	v, err := json.Marshal(w)
+	if err != nil {
+		var diags diag.Diagnostics
+		diags.AddError("Failed to record pending writes", err.Error())
+		return diags
+	}
+	return p.SetKey(ctx, pendingWritesKey, v)
+}
+
+// --- Business logic functions (testable independently) ---
+
+// branchFileStatus is the status of a file in one of its branches.
+type branchFileStatus struct {
+	branch string
+	// blobSHA is the blob SHA of the file in the branch, or empty if it
+	// does not exist there.
+	blobSHA string
+	inSync  bool
+}
+
+// validateBranchPattern returns an error if the given branch pattern is not
+// a valid glob.
+func validateBranchPattern(v string) error {
+	if v == "" {
+		return errors.New("the branch pattern must not be empty")
+	}
+	for _, s := range strings.Split(v, "/") {
+		if _, err := pathpkg.Match(s, ""); err != nil {
+			return fmt.Errorf("%q is not a valid glob: %v", v, err)
+		}
+	}
+	return nil
+}
+
+// listMatchingBranches returns the branches of the given repository matching
+// the given pattern, sorted by name. The working branches of the provider are
+// left out, as files are written to them on the way to their targets.
+func listMatchingBranches(ctx context.Context, c *providerConfiguration, owner, repo, pattern string) ([]string, error) {
+	gc := c.client(owner)
+
+	// Only the branches starting with the pattern's literal prefix can match.
+	prefix := pattern
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		prefix = pattern[:i]
+	}
+	var refs []*github.Reference
+	o := &github.ReferenceListOptions{
+		Ref:         "heads/" + prefix,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		v, res, err := gc.Git.ListMatchingRefs(ctx, owner, repo, o)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list branches of %s/%s matching %q: %v", owner, repo, pattern, err)
+		}
+		refs = append(refs, v...)
+		if res.NextPage == 0 {
+			break
+		}
+		o.Page = res.NextPage
+	}
+
+	branches := []string{}
+	for _, ref := range refs {
+		b := strings.TrimPrefix(ref.GetRef(), "refs/heads/")
+		if c.branchPrefix != "" && strings.HasPrefix(b, c.branchPrefix) {
+			continue
+		}
+		if matchGlob(pattern, b) {
+			branches = append(branches, b)
+		}
+	}
+	sort.Strings(branches)
+	return branches, nil
+}
+
+// removedBranches returns the statuses among prior of the branches holding
+// the file which are not among current.
+func removedBranches(prior, current []branchFileStatus) []branchFileStatus {
+	keep := map[string]bool{}
+	for _, s := range current {
+		keep[s.branch] = true
+	}
+	var removed []branchFileStatus
+	for _, s := range prior {
+		if !keep[s.branch] && s.blobSHA != "" {
+			removed = append(removed, s)
+		}
+	}
+	return removed
+}
+
+// readBranchFiles returns the status of the file at path p in each of the
+// given branches, which is in sync if it has the given contents.
+func readBranchFiles(ctx context.Context, c *providerConfiguration, owner, repo, p, contents string, branches []string) ([]branchFileStatus, error) {
+	gc := c.client(owner)
+	want := gitBlobSHA(contents)
+	statuses := make([]branchFileStatus, 0, len(branches))
+	for _, b := range branches {
+		s := branchFileStatus{branch: b}
+		h, err := ghfileutils.GetFile(ctx, gc, owner, repo, b, p)
+		switch {
+		case err == ghfileutils.ErrNotFound:
+		case err != nil:
+			return nil, fmt.Errorf("failed to read %q in branch %q: %v", p, b, err)
+		default:
+			s.blobSHA = h.GetSHA()
+			s.inSync = s.blobSHA == want
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// writeBranchFiles writes the given contents to the file at path p in each
+// branch of the given statuses which is not in sync with them, creating it
+// where it does not exist, and returns their statuses afterwards. A failure
+// to write to one branch does not stop the others from being written to: the
+// branches which failed keep their status, and all failures are returned
+// together. The write to each branch resumes or cleans up its pending write
+// among the given ones, which are updated with those the writes leave.
+func writeBranchFiles(ctx context.Context, c *providerConfiguration, owner, repo, p, contents string, statuses []branchFileStatus, pending map[string]*pendingWrite) ([]branchFileStatus, error) {
+	var errs []error
+	written := make([]branchFileStatus, 0, len(statuses))
+	for _, s := range statuses {
+		if s.inSync {
+			written = append(written, s)
+			continue
+		}
+		f := &file{
+			repositoryOwner: owner,
+			repositoryName:  repo,
+			branch:          s.branch,
+			path:            p,
+			contents:        contents,
+			pendingWrite:    pending[s.branch],
+		}
+		message := "Update %q."
+		if s.blobSHA == "" {
+			message = "Create %q."
+		}
+		err := createOrUpdateFile(ctx, c, f, message)
+		setBranchPendingWrite(pending, s.branch, f.pendingWrite)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("branch %q: %w", s.branch, err))
+			written = append(written, s)
+			continue
+		}
+		written = append(written, branchFileStatus{branch: s.branch, blobSHA: gitBlobSHA(contents), inSync: true})
+	}
+	return written, errors.Join(errs...)
+}
+
+// deleteBranchFiles deletes the file at path p from each of the given
+// branches, carrying on past failures and updating the given pending writes
+// as writeBranchFiles does, and returns the branches it could not be deleted
+// from.
+func deleteBranchFiles(ctx context.Context, c *providerConfiguration, owner, repo, p string, branches []string, pending map[string]*pendingWrite) ([]string, error) {
+	var failed []string
+	var errs []error
+	for _, b := range branches {
+		f := &file{
+			repositoryOwner: owner,
+			repositoryName:  repo,
+			branch:          b,
+			path:            p,
+			pendingWrite:    pending[b],
+		}
+		err := deleteFile(ctx, c, f)
+		setBranchPendingWrite(pending, b, f.pendingWrite)
+		if err != nil {
+			failed = append(failed, b)
+			errs = append(errs, fmt.Errorf("branch %q: %w", b, err))
+		}
+	}
+	return failed, errors.Join(errs...)
+}
+
+// setBranchPendingWrite records the given pending write of branch b among the
+// given ones, or removes that of b if there is none.
+func setBranchPendingWrite(pending map[string]*pendingWrite, b string, w *pendingWrite) {
+	if w == nil {
+		delete(pending, b)
+		return
+	}
+	pending[b] = w
+}
@@ -0,0 +1,444 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+// newBranchFileServer serves ci.yml with the given contents in each branch,
+// and fails to serve it in the branches mapped to an empty string.
+func newBranchFileServer(contents map[string]string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo/contents/ci.yml", func(w http.ResponseWriter, r *http.Request) {
+		v, ok := contents[r.URL.Query().Get("ref")]
+		switch {
+		case !ok:
+			w.WriteHeader(http.StatusNotFound)
+		case v == "":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"type":"file","path":"ci.yml","sha":%q,"encoding":"base64","content":%q}`,
+				gitBlobSHA(v), base64.StdEncoding.EncodeToString([]byte(v)))
+		}
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestListMatchingBranches(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo/git/matching-refs/heads/release/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[
+			{"ref":"refs/heads/release/2.0","object":{"sha":"a"}},
+			{"ref":"refs/heads/release/1.0","object":{"sha":"b"}},
+			{"ref":"refs/heads/release/1.0/hotfix","object":{"sha":"c"}}
+		]`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/git/matching-refs/heads/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[
+			{"ref":"refs/heads/main","object":{"sha":"a"}},
+			{"ref":"refs/heads/release/1.0","object":{"sha":"b"}},
+			{"ref":"refs/heads/tf-write-1234","object":{"sha":"d"}}
+		]`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{
+		githubClient: newMockGitHubClient(server),
+		branchPrefix: "tf-",
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"release/*", []string{"release/1.0", "release/2.0"}},
+		{"release/**", []string{"release/1.0", "release/1.0/hotfix", "release/2.0"}},
+		{"*", []string{"main"}},
+		{"release/3.*", []string{}},
+	}
+	for _, tt := range tests {
+		got, err := listMatchingBranches(context.Background(), config, "test-owner", "test-repo", tt.pattern)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tt.pattern, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: expected branches %v, got %v", tt.pattern, tt.want, got)
+		}
+	}
+}
+
+func TestReadBranchFiles(t *testing.T) {
+	server := newBranchFileServer(map[string]string{
+		"release/1.0": "steps: [test]\n",
+		"release/2.0": "steps: [build]\n",
+	})
+	defer server.Close()
+
+	config := &providerConfiguration{
+		githubClient: newMockGitHubClient(server),
+	}
+
+	got, err := readBranchFiles(context.Background(), config, "test-owner", "test-repo", "ci.yml", "steps: [test]\n",
+		[]string{"release/1.0", "release/2.0", "release/3.0"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []branchFileStatus{
+		{branch: "release/1.0", blobSHA: gitBlobSHA("steps: [test]\n"), inSync: true},
+		{branch: "release/2.0", blobSHA: gitBlobSHA("steps: [build]\n")},
+		{branch: "release/3.0"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected statuses %+v, got %+v", want, got)
+	}
+}
+
+func TestWriteBranchFiles_ContinuesPastFailures(t *testing.T) {
+	server := newBranchFileServer(map[string]string{
+		"release/1.0": "",
+		"release/2.0": "steps: [test]\n",
+		"release/3.0": "",
+	})
+	defer server.Close()
+
+	config := &providerConfiguration{
+		githubClient: newMockGitHubClient(server),
+	}
+
+	statuses, err := writeBranchFiles(context.Background(), config, "test-owner", "test-repo", "ci.yml", "steps: [test]\n", []branchFileStatus{
+		{branch: "release/1.0", blobSHA: "old"},
+		{branch: "release/2.0", blobSHA: "old"},
+		{branch: "release/3.0", blobSHA: "old"},
+	}, map[string]*pendingWrite{})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, b := range []string{"release/1.0", "release/3.0"} {
+		if !strings.Contains(err.Error(), fmt.Sprintf("branch %q", b)) {
+			t.Errorf("expected the error to report branch %q, got: %v", b, err)
+		}
+	}
+	if strings.Contains(err.Error(), `branch "release/2.0"`) {
+		t.Errorf("expected the error not to report the branch already in sync, got: %v", err)
+	}
+	want := []branchFileStatus{
+		{branch: "release/1.0", blobSHA: "old"},
+		{branch: "release/2.0", blobSHA: gitBlobSHA("steps: [test]\n"), inSync: true},
+		{branch: "release/3.0", blobSHA: "old"},
+	}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("expected statuses %+v, got %+v", want, statuses)
+	}
+}
+
+func TestValidateBranchPattern(t *testing.T) {
+	for _, v := range []string{"release/*", "release/**", "v[0-9]*", "main"} {
+		if err := validateBranchPattern(v); err != nil {
+			t.Errorf("%q: unexpected error: %v", v, err)
+		}
+	}
+	for _, v := range []string{"", "release/[", `release\`} {
+		if err := validateBranchPattern(v); err == nil {
+			t.Errorf("%q: expected an error", v)
+		}
+	}
+}
+
+func TestWriteBranchFiles_CommitMessages(t *testing.T) {
+	var messages []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo/git/commits", func(w http.ResponseWriter, r *http.Request) {
+		var v struct {
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, v.Message)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/pulls", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[]`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/git/ref/heads/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"ref":"refs/heads/release","object":{"sha":"base-sha"}}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/commits/base-sha", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"sha":"base-sha","commit":{"tree":{"sha":"base-tree"}}}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/git/trees", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"sha":"tree-sha"}`)
+	})
+	// The file and the working branches left behind are missing.
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{
+		githubClient:   newMockGitHubClient(server),
+		branchPrefix:   "tf-",
+		githubEmail:    "bot@example.com",
+		githubUsername: "bot",
+	}
+	statuses := []branchFileStatus{
+		{branch: "release/1.0", blobSHA: "old"},
+		{branch: "release/2.0"},
+		{branch: "release/3.0", blobSHA: gitBlobSHA("steps: [test]\n"), inSync: true},
+	}
+	if _, err := writeBranchFiles(context.Background(), config, "test-owner", "test-repo", "ci.yml", "steps: [test]\n", statuses, map[string]*pendingWrite{}); err == nil {
+		t.Fatal("expected the commits to fail")
+	}
+	if expected := []string{`Update "ci.yml".`, `Create "ci.yml".`}; !reflect.DeepEqual(messages, expected) {
+		t.Errorf("expected commit messages %q, got %q", expected, messages)
+	}
+}
+
+func TestRemovedBranches(t *testing.T) {
+	prior := []branchFileStatus{
+		{branch: "release/1.0", blobSHA: "a", inSync: true},
+		{branch: "release/2.0", blobSHA: "b", inSync: true},
+		{branch: "release/3.0"},
+	}
+	current := []branchFileStatus{
+		{branch: "release/2.0", blobSHA: "b", inSync: true},
+	}
+	want := []branchFileStatus{{branch: "release/1.0", blobSHA: "a", inSync: true}}
+	if got := removedBranches(prior, current); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected removed branches %+v, got %+v", want, got)
+	}
+}
+
+func TestDeleteBranchFiles_ReportsFailedBranches(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"name":"test-repo","archived":false}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/contents/ci.yml", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("ref") == "release/2.0" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{
+		githubClient: newMockGitHubClient(server),
+	}
+
+	failed, err := deleteBranchFiles(context.Background(), config, "test-owner", "test-repo", "ci.yml", []string{"release/1.0", "release/2.0"}, map[string]*pendingWrite{})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if expected := []string{"release/2.0"}; !reflect.DeepEqual(failed, expected) {
+		t.Errorf("expected failed branches %v, got %v", expected, failed)
+	}
+}
+
+func TestBranchFile_ReadOnly(t *testing.T) {
+	ctx := context.Background()
+	// Any request reaching the server means the change was not rejected
+	// before reading anything.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to %s", r.URL.Path)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	r := &branchFileResource{config: &providerConfiguration{
+		githubClient: newMockGitHubClient(server),
+		readOnly:     true,
+	}}
+	var sr resource.SchemaResponse
+	r.Schema(ctx, resource.SchemaRequest{}, &sr)
+	null := tftypes.NewValue(sr.Schema.Type().TerraformType(ctx), nil)
+
+	inSync, _ := types.ObjectValue(branchStatusType.AttrTypes, map[string]attr.Value{
+		"blob_sha": types.StringValue(gitBlobSHA("steps: [test]\n")),
+		"in_sync":  types.BoolValue(true),
+	})
+	outOfSync, _ := types.ObjectValue(branchStatusType.AttrTypes, map[string]attr.Value{
+		"blob_sha": types.StringValue(""),
+		"in_sync":  types.BoolValue(false),
+	})
+	newModel := func(status attr.Value) branchFileResourceModel {
+		return branchFileResourceModel{
+			ID:              types.StringValue("test-owner/test-repo:ci.yml"),
+			RepositoryOwner: types.StringValue("test-owner"),
+			RepositoryName:  types.StringValue("test-repo"),
+			Branches:        types.ListValueMust(types.StringType, []attr.Value{types.StringValue("release/1.0")}),
+			BranchPattern:   types.StringNull(),
+			Path:            types.StringValue("ci.yml"),
+			Contents:        types.StringValue("steps: [test]\n"),
+			BranchStatus:    types.MapValueMust(branchStatusType, map[string]attr.Value{"release/1.0": status}),
+		}
+	}
+	newPlan := func(m branchFileResourceModel) tfsdk.Plan {
+		p := tfsdk.Plan{Schema: sr.Schema, Raw: null}
+		if diags := p.Set(ctx, &m); diags.HasError() {
+			t.Fatalf("failed to build plan: %v", diags)
+		}
+		return p
+	}
+	synced, unsynced := newPlan(newModel(inSync)), newPlan(newModel(outOfSync))
+
+	tests := map[string]struct {
+		req    resource.ModifyPlanRequest
+		reject bool
+	}{
+		"create":      {resource.ModifyPlanRequest{Plan: synced, State: tfsdk.State{Schema: sr.Schema, Raw: null}}, true},
+		"delete":      {resource.ModifyPlanRequest{Plan: tfsdk.Plan{Schema: sr.Schema, Raw: null}, State: tfsdk.State{Schema: sr.Schema, Raw: synced.Raw}}, true},
+		"out of sync": {resource.ModifyPlanRequest{Plan: unsynced, State: tfsdk.State{Schema: sr.Schema, Raw: unsynced.Raw}}, true},
+		"in sync":     {resource.ModifyPlanRequest{Plan: synced, State: tfsdk.State{Schema: sr.Schema, Raw: synced.Raw}}, false},
+	}
+	for name, tt := range tests {
+		resp := &resource.ModifyPlanResponse{Plan: tt.req.Plan}
+		r.ModifyPlan(ctx, tt.req, resp)
+		rejected := resp.Diagnostics.HasError() && resp.Diagnostics.Errors()[0].Summary() == "Provider Is Read-Only"
+		if rejected != tt.reject {
+			t.Errorf("%s: expected the plan to be rejected as read-only: %t, got: %v", name, tt.reject, resp.Diagnostics)
+		}
+	}
+}
+
+func TestBranchFile_CreatePartialFailure(t *testing.T) {
+	ctx := context.Background()
+	// The file is in sync in release/1.0, and missing from release/2.0,
+	// where writing it fails as its pull requests cannot be looked up.
+	server := newBranchFileServer(map[string]string{"release/1.0": "steps: [test]\n"})
+	defer server.Close()
+
+	r := &branchFileResource{config: &providerConfiguration{
+		githubClient: newMockGitHubClient(server),
+		branchPrefix: "tf-",
+	}}
+	var sr resource.SchemaResponse
+	r.Schema(ctx, resource.SchemaRequest{}, &sr)
+	plan := tfsdk.Plan{Schema: sr.Schema, Raw: tftypes.NewValue(sr.Schema.Type().TerraformType(ctx), nil)}
+	if diags := plan.Set(ctx, &branchFileResourceModel{
+		ID:              types.StringUnknown(),
+		RepositoryOwner: types.StringValue("test-owner"),
+		RepositoryName:  types.StringValue("test-repo"),
+		Branches:        types.ListValueMust(types.StringType, []attr.Value{types.StringValue("release/1.0"), types.StringValue("release/2.0")}),
+		BranchPattern:   types.StringNull(),
+		Path:            types.StringValue("ci.yml"),
+		Contents:        types.StringValue("steps: [test]\n"),
+		BranchStatus:    types.MapUnknown(branchStatusType),
+	}); diags.HasError() {
+		t.Fatalf("failed to build plan: %v", diags)
+	}
+
+	resp := &resource.CreateResponse{State: tfsdk.State{Schema: sr.Schema, Raw: plan.Raw}}
+	// The private state can only be made by the framework, as its type is
+	// internal to it.
+	p := reflect.ValueOf(&resp.Private).Elem()
+	p.Set(reflect.New(p.Type().Elem()))
+	r.Create(ctx, resource.CreateRequest{Plan: plan}, resp)
+
+	// An error would taint the resource, and have the next apply delete the
+	// file from release/1.0 as well.
+	if resp.Diagnostics.HasError() {
+		t.Fatalf("expected no error, got: %v", resp.Diagnostics)
+	}
+	if resp.Diagnostics.WarningsCount() != 1 || !strings.Contains(resp.Diagnostics.Warnings()[0].Detail(), `branch "release/2.0"`) {
+		t.Errorf("expected a warning about release/2.0, got: %v", resp.Diagnostics)
+	}
+	var state branchFileResourceModel
+	if diags := resp.State.Get(ctx, &state); diags.HasError() {
+		t.Fatalf("failed to read state: %v", diags)
+	}
+	statuses, _ := statusesFromModel(ctx, &state)
+	want := []branchFileStatus{
+		{branch: "release/1.0", blobSHA: gitBlobSHA("steps: [test]\n"), inSync: true},
+		{branch: "release/2.0"},
+	}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("expected statuses %+v, got %+v", want, statuses)
+	}
+
+	// The working branch of the failed write is recorded, for the next write
+	// to release/2.0 to clean up.
+	pending, diags := getPendingWrites(ctx, resp.Private)
+	if diags.HasError() {
+		t.Fatalf("failed to read private state: %v", diags)
+	}
+	if len(pending) != 1 || pending["release/2.0"] == nil || !strings.HasPrefix(pending["release/2.0"].Branch, "tf-") {
+		t.Errorf("expected a pending write in release/2.0, got %+v", pending)
+	}
+}
+
+func TestWriteBranchFiles_AbandonsPendingWrites(t *testing.T) {
+	var deleted []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo/git/refs/heads/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/repos/test-owner/test-repo/git/refs/heads/"))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/pulls", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[]`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/contents/ci.yml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"type":"file","path":"ci.yml","sha":%q}`, gitBlobSHA("steps: [test]\n"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{githubClient: newMockGitHubClient(server)}
+	// The file was written to release/1.0 by the interrupted write, which
+	// left its working branch behind.
+	pending := map[string]*pendingWrite{"release/1.0": {Branch: "tf-write-old"}}
+	if _, err := writeBranchFiles(context.Background(), config, "test-owner", "test-repo", "ci.yml", "steps: [test]\n", []branchFileStatus{
+		{branch: "release/1.0", blobSHA: "old"},
+	}, pending); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if expected := []string{"tf-write-old"}; !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("expected the branches %v to be deleted, got %v", expected, deleted)
+	}
+	if len(pending) != 0 {
+		t.Errorf("expected no pending writes, got %+v", pending)
+	}
+}
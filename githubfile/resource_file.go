@@ -16,6 +16,7 @@ package githubfile
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -23,20 +24,38 @@ import (
 	"time"
 
 	"github.com/form3tech-oss/go-github-utils/pkg/branch"
-	"github.com/form3tech-oss/go-github-utils/pkg/commit"
 	ghfileutils "github.com/form3tech-oss/go-github-utils/pkg/file"
 	"github.com/google/go-github/v54/github"
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 )
 
 var (
-	_ resource.Resource                = &fileResource{}
-	_ resource.ResourceWithConfigure   = &fileResource{}
-	_ resource.ResourceWithImportState = &fileResource{}
+	_ resource.Resource                   = &fileResource{}
+	_ resource.ResourceWithConfigure      = &fileResource{}
+	_ resource.ResourceWithImportState    = &fileResource{}
+	_ resource.ResourceWithModifyPlan     = &fileResource{}
+	_ resource.ResourceWithValidateConfig = &fileResource{}
+)
+
+const (
+	// conflictPolicyOverwrite overwrites changes made to a file outside of
+	// Terraform.
+	conflictPolicyOverwrite = "overwrite"
+	// conflictPolicyFail refuses to overwrite changes made to a file outside
+	// of Terraform since it last read it.
+	conflictPolicyFail = "fail"
+	// conflictPolicyMerge merges the changes made to a file outside of
+	// Terraform with the changes being applied.
+	conflictPolicyMerge = "merge"
 )
 
 type fileResource struct {
@@ -44,12 +63,25 @@ type fileResource struct {
 }
 
 type fileResourceModel struct {
-	ID              types.String `tfsdk:"id"`
-	RepositoryOwner types.String `tfsdk:"repository_owner"`
-	RepositoryName  types.String `tfsdk:"repository_name"`
-	Branch          types.String `tfsdk:"branch"`
-	Path            types.String `tfsdk:"path"`
-	Contents        types.String `tfsdk:"contents"`
+	ID                  types.String   `tfsdk:"id"`
+	RepositoryOwner     types.String   `tfsdk:"repository_owner"`
+	RepositoryName      types.String   `tfsdk:"repository_name"`
+	Branch              types.String   `tfsdk:"branch"`
+	Path                types.String   `tfsdk:"path"`
+	CreateBranch        types.Bool     `tfsdk:"create_branch"`
+	BaseBranch          types.String   `tfsdk:"base_branch"`
+	BaseSHA             types.String   `tfsdk:"base_sha"`
+	OrphanBranch        types.Bool     `tfsdk:"orphan_branch"`
+	Contents            types.String   `tfsdk:"contents"`
+	SensitiveContents   types.Bool     `tfsdk:"sensitive_contents"`
+	ConflictPolicy      types.String   `tfsdk:"conflict_policy"`
+	ConflictPullRequest types.Bool     `tfsdk:"conflict_pull_request"`
+	BlobSHA             types.String   `tfsdk:"blob_sha"`
+	CommitSHA           types.String   `tfsdk:"commit_sha"`
+	PullRequestNumber   types.Int64    `tfsdk:"pull_request_number"`
+	HTMLURL             types.String   `tfsdk:"html_url"`
+	LastModifiedBy      types.String   `tfsdk:"last_modified_by"`
+	Timeouts            timeouts.Value `tfsdk:"timeouts"`
 }
 
 // NewFileResource returns a new file resource.
@@ -61,7 +93,7 @@ func (r *fileResource) Metadata(_ context.Context, req resource.MetadataRequest,
 	resp.TypeName = req.ProviderTypeName + "_file"
 }
 
-func (r *fileResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+func (r *fileResource) Schema(ctx context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
 	resp.Schema = schema.Schema{
 		Attributes: map[string]schema.Attribute{
 			"id": schema.StringAttribute{
@@ -77,6 +109,9 @@ func (r *fileResource) Schema(_ context.Context, _ resource.SchemaRequest, resp
 				PlanModifiers: []planmodifier.String{
 					stringplanmodifier.RequiresReplace(),
 				},
+				Validators: []validator.String{
+					ownerValidator(),
+				},
 			},
 			"repository_name": schema.StringAttribute{
 				Required:    true,
@@ -84,13 +119,21 @@ func (r *fileResource) Schema(_ context.Context, _ resource.SchemaRequest, resp
 				PlanModifiers: []planmodifier.String{
 					stringplanmodifier.RequiresReplace(),
 				},
+				Validators: []validator.String{
+					repositoryNameValidator(),
+				},
 			},
 			"branch": schema.StringAttribute{
-				Required:    true,
-				Description: "The branch in which to create the file.",
+				Optional:    true,
+				Computed:    true,
+				Description: "The branch in which to create the file. Defaults to the repository's default branch, which is then tracked: if it changes, the file is replaced by one in the new default branch.",
 				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
 					stringplanmodifier.RequiresReplace(),
 				},
+				Validators: []validator.String{
+					branchValidator(),
+				},
 			},
 			"path": schema.StringAttribute{
 				Required:    true,
@@ -98,11 +141,73 @@ func (r *fileResource) Schema(_ context.Context, _ resource.SchemaRequest, resp
 				PlanModifiers: []planmodifier.String{
 					stringplanmodifier.RequiresReplace(),
 				},
+				Validators: []validator.String{
+					filePathValidator(),
+				},
+			},
+			"create_branch": schema.BoolAttribute{
+				Optional:    true,
+				Description: "Whether to create the branch if it does not exist. Defaults to false.",
+			},
+			"base_branch": schema.StringAttribute{
+				Optional:    true,
+				Description: "The branch from whose head to create the branch when create_branch is set. Defaults to the repository's default branch.",
+				Validators: []validator.String{
+					branchValidator(),
+				},
+			},
+			"base_sha": schema.StringAttribute{
+				Optional:    true,
+				Description: "The SHA of the commit from which to create the branch when create_branch is set, instead of the head of base_branch.",
+			},
+			"orphan_branch": schema.BoolAttribute{
+				Optional:    true,
+				Description: "Whether to create the branch, when create_branch is set, as an orphan branch (e.g. gh-pages) starting with a commit of an empty tree, instead of from a base. Defaults to false.",
 			},
 			"contents": schema.StringAttribute{
 				Required:    true,
 				Description: "The contents of the file.",
 			},
+			"sensitive_contents": schema.BoolAttribute{
+				Optional:    true,
+				Description: "Whether the contents are sensitive, in which case plans do not show a diff of the changes to them. Defaults to false.",
+			},
+			"conflict_policy": schema.StringAttribute{
+				Optional:    true,
+				Description: "What to do when updating or deleting a file which was changed outside of Terraform since it last read it: \"overwrite\" the changes, \"fail\" with a diff of them, or \"merge\" them with the changes being applied. Defaults to \"overwrite\".",
+			},
+			"conflict_pull_request": schema.BoolAttribute{
+				Optional:    true,
+				Description: "Whether to open a pull request with conflict markers for humans to resolve when conflict_policy is \"merge\" and the changes made outside of Terraform conflict with the changes being applied. Defaults to false, in which case the conflicts are only reported.",
+			},
+			"blob_sha": schema.StringAttribute{
+				Computed:    true,
+				Description: "The SHA of the blob holding the file's contents.",
+			},
+			"commit_sha": schema.StringAttribute{
+				Computed:    true,
+				Description: "The SHA of the last commit that wrote the file.",
+			},
+			"pull_request_number": schema.Int64Attribute{
+				Computed:    true,
+				Description: "The number of the pull request through which the last commit that wrote the file was merged, if any.",
+			},
+			"html_url": schema.StringAttribute{
+				Computed:    true,
+				Description: "The URL at which the file can be viewed on GitHub.",
+			},
+			"last_modified_by": schema.StringAttribute{
+				Computed:    true,
+				Description: "The GitHub login (or, failing that, the name) of the author of the last commit that wrote the file.",
+			},
+		},
+		Blocks: map[string]schema.Block{
+			"timeouts": timeouts.Block(ctx, timeouts.Opts{
+				Create: true,
+				Read:   true,
+				Update: true,
+				Delete: true,
+			}),
 		},
 	}
 }
@@ -129,9 +234,33 @@ func (r *fileResource) Create(ctx context.Context, req resource.CreateRequest, r
 		return
 	}
 
+	t, diags := plan.Timeouts.Create(ctx, defaultWriteTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, t)
+	defer cancel()
+
+	if r.config.readOnly {
+		resp.Diagnostics.AddError("Failed to create file", errReadOnly.Error())
+		return
+	}
 	f := modelToFile(&plan)
-	if err := createOrUpdateFile(ctx, r.config, f, "Create %q."); err != nil {
+	resp.Diagnostics.Append(r.trackDefaultBranch(ctx, req.Config, f)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	err := createOrUpdateFile(ctx, r.config, f, "Create %q.")
+	resp.Diagnostics.Append(setPendingWrite(ctx, resp.Private, f.pendingWrite)...)
+	if err != nil {
 		resp.Diagnostics.AddError("Failed to create file", err.Error())
+		// A file whose write left a working branch behind is recorded, and
+		// so replaced by the next apply, which cleans the branch up.
+		if f.pendingWrite != nil {
+			fileToModel(f, &plan)
+			resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+		}
 		return
 	}
 
@@ -151,10 +280,24 @@ func (r *fileResource) Read(ctx context.Context, req resource.ReadRequest, resp
 		return
 	}
 
+	t, diags := state.Timeouts.Read(ctx, defaultReadTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, t)
+	defer cancel()
+
 	f := modelToFile(&state)
 	if err := readFile(ctx, r.config, f); err != nil {
 		if errors.Is(err, errFileNotFound) {
-			resp.State.RemoveResource(ctx)
+			// A file whose creation failed is kept until its replacement
+			// has cleaned up the working branch it left behind.
+			p, diags := getPendingWrite(ctx, req.Private)
+			resp.Diagnostics.Append(diags...)
+			if p == nil {
+				resp.State.RemoveResource(ctx)
+			}
 			return
 		}
 		resp.Diagnostics.AddError("Failed to read file", err.Error())
@@ -172,8 +315,39 @@ func (r *fileResource) Update(ctx context.Context, req resource.UpdateRequest, r
 		return
 	}
 
+	t, diags := plan.Timeouts.Update(ctx, defaultWriteTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, t)
+	defer cancel()
+
+	var state fileResourceModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if r.config.readOnly {
+		resp.Diagnostics.AddError("Failed to update file", errReadOnly.Error())
+		return
+	}
 	f := modelToFile(&plan)
-	if err := createOrUpdateFile(ctx, r.config, f, "Update %q."); err != nil {
+	resp.Diagnostics.Append(r.trackDefaultBranch(ctx, req.Config, f)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	f.knownBlobSHA = state.BlobSHA.ValueString()
+	f.knownContents = state.Contents.ValueString()
+	f.pendingWrite, diags = getPendingWrite(ctx, req.Private)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	err := createOrUpdateFile(ctx, r.config, f, "Update %q.")
+	resp.Diagnostics.Append(setPendingWrite(ctx, resp.Private, f.pendingWrite)...)
+	if err != nil {
 		resp.Diagnostics.AddError("Failed to update file", err.Error())
 		return
 	}
@@ -194,8 +368,29 @@ func (r *fileResource) Delete(ctx context.Context, req resource.DeleteRequest, r
 		return
 	}
 
+	t, diags := state.Timeouts.Delete(ctx, defaultWriteTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, t)
+	defer cancel()
+
+	if r.config.readOnly {
+		resp.Diagnostics.AddError("Failed to delete file", errReadOnly.Error())
+		return
+	}
 	f := modelToFile(&state)
-	if err := deleteFile(ctx, r.config, f); err != nil {
+	f.knownBlobSHA = f.blobSHA
+	f.knownContents = f.contents
+	f.pendingWrite, diags = getPendingWrite(ctx, req.Private)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	err := deleteFile(ctx, r.config, f)
+	resp.Diagnostics.Append(setPendingWrite(ctx, resp.Private, f.pendingWrite)...)
+	if err != nil {
 		resp.Diagnostics.AddError("Failed to delete file", err.Error())
 		return
 	}
@@ -213,6 +408,13 @@ func (r *fileResource) ImportState(ctx context.Context, req resource.ImportState
 		repositoryName:  rn,
 		branch:          b,
 		path:            p,
+		// An ID without a branch is that of a file in the default branch,
+		// which is tracked.
+		trackDefaultBranch: b == "",
+	}
+	if err := resolveDefaultBranch(ctx, r.config, f); err != nil {
+		resp.Diagnostics.AddError("Failed to resolve default branch during import", err.Error())
+		return
 	}
 
 	if err := readFile(ctx, r.config, f); err != nil {
@@ -221,46 +423,307 @@ func (r *fileResource) ImportState(ctx context.Context, req resource.ImportState
 	}
 
 	var model fileResourceModel
+	resp.Diagnostics.Append(resp.State.GetAttribute(ctx, path.Root("timeouts"), &model.Timeouts)...)
 	fileToModel(f, &model)
 	resp.Diagnostics.Append(resp.State.Set(ctx, &model)...)
 }
 
+// ModifyPlan rejects changes to files if the provider is read-only, resolves
+// the default branch of files which track it, rejects files which would be
+// created where the remote has a directory, runs the preflight checks of
+// files being written if they are enabled, and warns about changes to the
+// contents of a file with a diff of them, which Terraform itself only shows
+// as the replacement of one string by another.
+func (r *fileResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	if req.Plan.Raw.IsNull() {
+		rejectReadOnlyChange(r.config, req, resp, "file")
+		return
+	}
+	var plan, state fileResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	if !req.State.Raw.IsNull() {
+		resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	}
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	creating := req.State.Raw.IsNull()
+	resp.Diagnostics.Append(r.planDefaultBranch(ctx, req, resp, &plan, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if rejectReadOnlyChange(r.config, req, resp, "file") {
+		return
+	}
+	f := modelToFile(&plan)
+
+	known := f.repositoryOwner != "" && f.repositoryName != "" && f.branch != "" && f.path != ""
+	if r.config != nil && known {
+		if creating {
+			if err := checkPathIsNotDirectory(ctx, r.config, f); err != nil {
+				resp.Diagnostics.AddAttributeError(path.Root("path"), "Invalid File Path", err.Error())
+			}
+		}
+		if r.config.preflight != nil && (creating || !plan.Contents.Equal(state.Contents)) {
+			problems := r.config.preflight.check(ctx, r.config, f.repositoryOwner, f.repositoryName, f.branch)
+			resp.Diagnostics.Append(preflightDiagnostics(problems, f.createBranch)...)
+		}
+	}
+	if creating {
+		return
+	}
+
+	size := defaultPlanDiffMaxSize
+	if r.config != nil {
+		size = r.config.planDiffMaxSize
+	}
+	if size == 0 || plan.SensitiveContents.ValueBool() || plan.Contents.IsUnknown() {
+		return
+	}
+	d := unifiedDiff(state.Contents.ValueString(), plan.Contents.ValueString(), "a/"+f.path, "b/"+f.path)
+	if d == "" {
+		return
+	}
+	resp.Diagnostics.AddAttributeWarning(
+		path.Root("contents"),
+		"File Contents Will Change",
+		fmt.Sprintf("The contents of %q in %s/%s (branch %q) will change:\n\n%s", f.path, f.repositoryOwner, f.repositoryName, f.branch, truncateDiff(d, size)),
+	)
+}
+
+// planDefaultBranch plans the branch of a file which tracks the default
+// branch of its repository, replacing the file if the default branch has
+// changed, and plans the file's ID, which depends on whether it does.
+func (r *fileResource) planDefaultBranch(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse, plan, state *fileResourceModel) diag.Diagnostics {
+	var diags diag.Diagnostics
+	var b types.String
+	diags.Append(req.Config.GetAttribute(ctx, path.Root("branch"), &b)...)
+	if diags.HasError() {
+		return diags
+	}
+	f := modelToFile(plan)
+	f.trackDefaultBranch = b.IsNull()
+	if f.trackDefaultBranch && r.config != nil && f.repositoryOwner != "" && f.repositoryName != "" {
+		v, err := branch.GetDefaultBranch(ctx, r.config.client(f.repositoryOwner), f.repositoryOwner, f.repositoryName)
+		if err != nil {
+			diags.AddAttributeError(path.Root("branch"), "Failed to Resolve Default Branch",
+				fmt.Sprintf("Failed to look up the default branch of %s/%s: %v", f.repositoryOwner, f.repositoryName, err))
+			return diags
+		}
+		plan.Branch = types.StringValue(v)
+		f.branch = v
+		if !req.State.Raw.IsNull() && !state.Branch.Equal(plan.Branch) {
+			resp.RequiresReplace = append(resp.RequiresReplace, path.Root("branch"))
+		}
+	}
+	if f.repositoryOwner != "" && f.repositoryName != "" && f.path != "" && (f.trackDefaultBranch || !plan.Branch.IsUnknown()) {
+		plan.ID = types.StringValue(fileID(f))
+	}
+	diags.Append(resp.Plan.Set(ctx, plan)...)
+	return diags
+}
+
+// trackDefaultBranch records whether the given file tracks the default
+// branch of its repository, as it does when the given configuration leaves
+// its branch out, and resolves the default branch if it is not known yet.
+func (r *fileResource) trackDefaultBranch(ctx context.Context, config tfsdk.Config, f *file) diag.Diagnostics {
+	var diags diag.Diagnostics
+	var b types.String
+	diags.Append(config.GetAttribute(ctx, path.Root("branch"), &b)...)
+	if diags.HasError() {
+		return diags
+	}
+	f.trackDefaultBranch = b.IsNull()
+	if err := resolveDefaultBranch(ctx, r.config, f); err != nil {
+		diags.AddAttributeError(path.Root("branch"), "Failed to Resolve Default Branch", err.Error())
+	}
+	return diags
+}
+
+func (r *fileResource) ValidateConfig(ctx context.Context, req resource.ValidateConfigRequest, resp *resource.ValidateConfigResponse) {
+	var config fileResourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &config)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if v := config.ConflictPolicy; !v.IsNull() && !v.IsUnknown() {
+		switch v.ValueString() {
+		case conflictPolicyOverwrite, conflictPolicyFail, conflictPolicyMerge:
+		default:
+			resp.Diagnostics.AddAttributeError(
+				path.Root("conflict_policy"),
+				"Invalid Conflict Policy",
+				fmt.Sprintf("conflict_policy must be one of %q, %q or %q, got %q.", conflictPolicyOverwrite, conflictPolicyFail, conflictPolicyMerge, v.ValueString()),
+			)
+		}
+	}
+	if !config.CreateBranch.IsUnknown() && !config.CreateBranch.ValueBool() {
+		for _, a := range []struct {
+			name string
+			set  bool
+		}{
+			{"base_branch", !config.BaseBranch.IsNull()},
+			{"base_sha", !config.BaseSHA.IsNull()},
+			{"orphan_branch", config.OrphanBranch.ValueBool()},
+		} {
+			if a.set {
+				resp.Diagnostics.AddAttributeError(
+					path.Root(a.name),
+					"Invalid Branch Creation",
+					fmt.Sprintf("%s can only be set when create_branch is true.", a.name),
+				)
+			}
+		}
+	}
+	var bases []string
+	if !config.BaseBranch.IsNull() {
+		bases = append(bases, "base_branch")
+	}
+	if !config.BaseSHA.IsNull() {
+		bases = append(bases, "base_sha")
+	}
+	if config.OrphanBranch.ValueBool() {
+		bases = append(bases, "orphan_branch")
+	}
+	if len(bases) > 1 {
+		resp.Diagnostics.AddAttributeError(
+			path.Root(bases[1]),
+			"Invalid Branch Creation",
+			fmt.Sprintf("Only one of base_branch, base_sha and orphan_branch can be set, got %s.", strings.Join(bases, " and ")),
+		)
+	}
+	if config.ConflictPullRequest.ValueBool() && !config.ConflictPolicy.IsUnknown() && config.ConflictPolicy.ValueString() != conflictPolicyMerge {
+		resp.Diagnostics.AddAttributeError(
+			path.Root("conflict_pull_request"),
+			"Invalid Conflict Pull Request",
+			fmt.Sprintf("conflict_pull_request can only be set when conflict_policy is %q.", conflictPolicyMerge),
+		)
+	}
+}
+
 // --- Business logic functions (testable independently) ---
 
-var errFileNotFound = errors.New("file not found")
+const (
+	// defaultReadTimeout is how long reading a file may take by default.
+	defaultReadTimeout = 5 * time.Minute
+	// defaultWriteTimeout is how long creating, updating or deleting a file
+	// may take by default, including merging the pull request.
+	defaultWriteTimeout = 20 * time.Minute
+	// cleanupTimeout is how long cleaning up after a write that timed out may
+	// take.
+	cleanupTimeout = time.Minute
+)
+
+var (
+	errFileNotFound = errors.New("file not found")
+	errReadOnly     = errors.New("the provider is configured with read_only = true, so no resource can be created, updated or deleted")
+)
+
+// rejectReadOnlyChange rejects the planned change to a resource of the given
+// kind if the provider is read-only and the change creates, updates or
+// deletes it, returning whether it did. It is called at the end of planning,
+// once the resource has made its own changes to the plan.
+func rejectReadOnlyChange(c *providerConfiguration, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse, kind string) bool {
+	if c == nil || !c.readOnly {
+		return false
+	}
+	var op string
+	switch {
+	case req.Plan.Raw.IsNull():
+		op = "delete"
+	case req.State.Raw.IsNull():
+		op = "create"
+	case len(resp.RequiresReplace) > 0:
+		op = "replace"
+	case !resp.Plan.Raw.Equal(req.State.Raw):
+		op = "update"
+	default:
+		return false
+	}
+	resp.Diagnostics.AddError("Provider Is Read-Only", fmt.Sprintf("Cannot %s %s: %v.", op, kind, errReadOnly))
+	return true
+}
 
 func createOrUpdateFile(ctx context.Context, c *providerConfiguration, f *file, s string) error {
+	if c.readOnly {
+		return errReadOnly
+	}
+	gc := c.client(f.repositoryOwner)
+	if err := ensureBranch(ctx, c, f); err != nil {
+		return err
+	}
+
+	// The contents may already be in place, e.g. if the apply which wrote
+	// them was interrupted before recording it.
+	h, err := ghfileutils.GetFile(ctx, gc, f.repositoryOwner, f.repositoryName, f.branch, f.path)
+	if err != nil && err != ghfileutils.ErrNotFound {
+		return err
+	}
+	if err == nil && h.GetSHA() == gitBlobSHA(f.contents) {
+		abandonPendingWrite(ctx, gc, f, "")
+		return nil
+	}
+	if err := checkConflict(f, h); err != nil {
+		return err
+	}
+	contents := f.contents
+	if f.conflictPolicy == conflictPolicyMerge && f.knownBlobSHA != "" && h != nil && h.GetSHA() != gitBlobSHA(f.knownContents) {
+		contents, err = mergeFile(ctx, c, f, h)
+		if err != nil {
+			return err
+		}
+		if h.GetSHA() == gitBlobSHA(contents) {
+			abandonPendingWrite(ctx, gc, f, "")
+			return nil
+		}
+	}
+
 	entries := []*github.TreeEntry{
 		{
-			Content: github.String(f.contents),
+			Content: github.String(contents),
 			Mode:    github.String("100644"),
 			Path:    github.String(f.path),
 			Type:    github.String("blob"),
 		},
 	}
-	if err := commit.CreateCommit(ctx, c.githubClient, &commit.CommitOptions{
-		RepoOwner:                   f.repositoryOwner,
-		RepoName:                    f.repositoryName,
-		Branch:                      f.branch,
-		CommitMessage:               formatCommitMessage(c.commitMessagePrefix, s, f.path),
-		GpgPassphrase:               c.gpgPassphrase,
-		GpgPrivateKey:               c.gpgSecretKey,
-		Username:                    c.githubUsername,
-		Email:                       c.githubEmail,
-		Changes:                     entries,
-		PullRequestSourceBranchName: fmt.Sprintf("terraform-provider-githubfile-%d", time.Now().UnixNano()),
-		PullRequestBody:             "",
-		MaxRetries:                  3,
-		RetryBackoff:                5 * time.Second,
-	}); err != nil {
-		return fmt.Errorf("failed to create commit: %v", err)
+	return commitChange(ctx, c, f,
+		workingBranchName(c.branchPrefix, f, "write", contents),
+		formatCommitMessage(c.commitMessagePrefix, s, f.path),
+		entries,
+		nil)
+}
+
+// checkPathIsNotDirectory returns an error if the file's path is that of a
+// directory in its branch. Failing to look the path up is left to the apply
+// to report.
+func checkPathIsNotDirectory(ctx context.Context, c *providerConfiguration, f *file) error {
+	_, d, _, err := c.client(f.repositoryOwner).Repositories.GetContents(ctx, f.repositoryOwner, f.repositoryName, f.path, &github.RepositoryContentGetOptions{
+		Ref: f.branch,
+	})
+	if err == nil && d != nil {
+		return fmt.Errorf("%q is a directory in branch %q of %s/%s, so it cannot be managed as a file", f.path, f.branch, f.repositoryOwner, f.repositoryName)
+	}
+	return nil
+}
+
+// resolveDefaultBranch sets the branch of a file which tracks the default
+// branch of its repository to it, unless the branch is already known.
+func resolveDefaultBranch(ctx context.Context, c *providerConfiguration, f *file) error {
+	if !f.trackDefaultBranch || f.branch != "" {
+		return nil
 	}
+	v, err := branch.GetDefaultBranch(ctx, c.client(f.repositoryOwner), f.repositoryOwner, f.repositoryName)
+	if err != nil {
+		return fmt.Errorf("failed to look up the default branch of %s/%s: %v", f.repositoryOwner, f.repositoryName, err)
+	}
+	f.branch = v
 	return nil
 }
 
 func readFile(ctx context.Context, c *providerConfiguration, f *file) error {
 	h, err := ghfileutils.GetFile(ctx,
-		c.githubClient,
+		c.client(f.repositoryOwner),
 		f.repositoryOwner,
 		f.repositoryName,
 		f.branch,
@@ -271,19 +734,66 @@ func readFile(ctx context.Context, c *providerConfiguration, f *file) error {
 	if err != nil {
 		return err
 	}
+	// The blob SHA identifies the contents, so when it is unchanged since the
+	// last read there is no need to decode the contents or look up the commit
+	// metadata again.
+	if f.blobSHA != "" && f.blobSHA == h.GetSHA() {
+		return nil
+	}
 	r, err := h.GetContent()
 	if err != nil {
 		return err
 	}
-	f.contents = r
+	// When merging, the contents Terraform applied stay in the state as the
+	// base of the next merge, rather than being replaced by the merged ones.
+	if f.conflictPolicy != conflictPolicyMerge {
+		f.contents = r
+	}
+	f.blobSHA = h.GetSHA()
+	f.htmlURL = h.GetHTMLURL()
+	return readFileMetadata(ctx, c, f)
+}
+
+func readFileMetadata(ctx context.Context, c *providerConfiguration, f *file) error {
+	gc := c.client(f.repositoryOwner)
+	commits, _, err := gc.Repositories.ListCommits(ctx, f.repositoryOwner, f.repositoryName, &github.CommitsListOptions{
+		SHA:         f.branch,
+		Path:        f.path,
+		ListOptions: github.ListOptions{PerPage: 1},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list commits for %q: %v", f.path, err)
+	}
+	if len(commits) == 0 {
+		return fmt.Errorf("no commits found for %q in branch %q", f.path, f.branch)
+	}
+	f.commitSHA = commits[0].GetSHA()
+	f.lastModifiedBy = commits[0].GetAuthor().GetLogin()
+	if f.lastModifiedBy == "" {
+		f.lastModifiedBy = commits[0].GetCommit().GetAuthor().GetName()
+	}
+
+	prs, _, err := gc.PullRequests.ListPullRequestsWithCommit(ctx, f.repositoryOwner, f.repositoryName, f.commitSHA, nil)
+	if err != nil {
+		return fmt.Errorf("failed to list pull requests for commit %s: %v", f.commitSHA, err)
+	}
+	f.pullRequestNumber = 0
+	if len(prs) > 0 {
+		f.pullRequestNumber = prs[0].GetNumber()
+	}
 	return nil
 }
 
 func deleteFile(ctx context.Context, c *providerConfiguration, f *file) error {
+	if c.readOnly {
+		return errReadOnly
+	}
+	gc := c.client(f.repositoryOwner)
+
 	// Check if the repository is archived. If so, skip the delete operation
 	// and just remove the resource from state, since archived repositories
 	// cannot be modified.
-	repo, _, err := c.githubClient.Repositories.Get(ctx, f.repositoryOwner, f.repositoryName)
+	repo, _, err := gc.Repositories.Get(ctx, f.repositoryOwner, f.repositoryName)
 	if err != nil {
 		return fmt.Errorf("failed to retrieve repository %s/%s: %v", f.repositoryOwner, f.repositoryName, err)
 	}
@@ -294,7 +804,7 @@ func deleteFile(ctx context.Context, c *providerConfiguration, f *file) error {
 
 	// Check whether the file exists.
 	fileContent, err := ghfileutils.GetFile(ctx,
-		c.githubClient,
+		gc,
 		f.repositoryOwner,
 		f.repositoryName,
 		f.branch,
@@ -302,14 +812,18 @@ func deleteFile(ctx context.Context, c *providerConfiguration, f *file) error {
 	)
 	if err != nil {
 		if err == ghfileutils.ErrNotFound {
+			abandonPendingWrite(ctx, gc, f, "")
 			return nil
 		}
 		return err
 	}
+	if err := checkConflict(f, fileContent); err != nil {
+		return err
+	}
 
 	// Get the tree that corresponds to the target branch.
 	s, err := branch.GetSHAForBranch(ctx,
-		c.githubClient,
+		gc,
 		f.repositoryOwner,
 		f.repositoryName,
 		f.branch)
@@ -324,47 +838,193 @@ func deleteFile(ctx context.Context, c *providerConfiguration, f *file) error {
 		Type: github.String("blob"),
 	}}
 	// Create a commit based on the new tree.
-	if err := commit.CreateCommit(ctx, c.githubClient, &commit.CommitOptions{
-		RepoOwner:                   f.repositoryOwner,
-		RepoName:                    f.repositoryName,
-		Branch:                      f.branch,
-		CommitMessage:               formatCommitMessage(c.commitMessagePrefix, "Delete %q.", f.path),
-		GpgPassphrase:               c.gpgPassphrase,
-		GpgPrivateKey:               c.gpgSecretKey,
-		Username:                    c.githubUsername,
-		Email:                       c.githubEmail,
-		Changes:                     newTree,
-		BaseTreeOverride:            &s,
-		PullRequestSourceBranchName: fmt.Sprintf("terraform-provider-githubfile-%d", time.Now().UnixNano()),
-		PullRequestBody:             "",
-		MaxRetries:                  3,
-		RetryBackoff:                5 * time.Second,
-	},
-	); err != nil {
-		return fmt.Errorf("failed to create commit: %v", err)
+	return commitChange(ctx, c, f,
+		workingBranchName(c.branchPrefix, f, "delete", ""),
+		formatCommitMessage(c.commitMessagePrefix, "Delete %q.", f.path),
+		newTree,
+		&s)
+}
+
+// conflictError is returned when a file was changed outside of Terraform
+// and its conflict policy forbids overwriting the changes.
+type conflictError struct {
+	path string
+	diff string
+}
+
+func (e *conflictError) Error() string {
+	return fmt.Sprintf("%q was changed outside of Terraform since it was last read, and conflict_policy is %q. "+
+		"Refresh the state and review the changes before applying again:\n\n%s", e.path, conflictPolicyFail, e.diff)
+}
+
+// checkConflict returns a conflictError if the file's conflict policy is to
+// fail and the given remote file, which is nil if the file does not exist,
+// differs from the one last known to Terraform.
+func checkConflict(f *file, h *github.RepositoryContent) error {
+	if f.conflictPolicy != conflictPolicyFail || f.knownBlobSHA == "" || h.GetSHA() == f.knownBlobSHA {
+		return nil
 	}
-	return nil
+	var remote string
+	if h != nil {
+		v, err := h.GetContent()
+		if err != nil {
+			return err
+		}
+		remote = v
+	}
+	return &conflictError{
+		path: f.path,
+		diff: unifiedDiff(f.knownContents, remote, "known/"+f.path, "remote/"+f.path),
+	}
+}
+
+// mergeConflictError is returned when the changes made to a file outside of
+// Terraform conflict with the changes being applied.
+type mergeConflictError struct {
+	path      string
+	conflicts []string
+	// pullRequestURL is the URL of the pull request opened for the conflicts
+	// to be resolved, if any.
+	pullRequestURL string
+}
+
+func (e *mergeConflictError) Error() string {
+	next := "Resolve them in the file and apply again."
+	if e.pullRequestURL != "" {
+		next = fmt.Sprintf("Resolve them in pull request %s, merge it and apply again.", e.pullRequestURL)
+	}
+	return fmt.Sprintf("%q was changed outside of Terraform in ways which conflict with the changes being applied. %s\n\n%s",
+		e.path, next, strings.Join(e.conflicts, "\n"))
+}
+
+// mergeFile merges the changes made to the file outside of Terraform since it
+// was last applied, which resulted in the given remote file, with the changes
+// being applied, returning the merged contents. If they conflict, a
+// mergeConflictError is returned, after opening a pull request for the
+// conflicts to be resolved if the file asks for one.
+func mergeFile(ctx context.Context, c *providerConfiguration, f *file, h *github.RepositoryContent) (string, error) {
+	remote, err := h.GetContent()
+	if err != nil {
+		return "", err
+	}
+	merged, conflicts := merge3(f.knownContents, remote, f.contents)
+	if len(conflicts) == 0 {
+		return merged, nil
+	}
+	e := &mergeConflictError{path: f.path, conflicts: conflicts}
+	if f.conflictPullRequest {
+		pr, err := openConflictPullRequest(ctx, c, f,
+			conflictBranchName(c.branchPrefix, f, merged),
+			formatCommitMessage(c.commitMessagePrefix, "Resolve conflicting changes to %q.", f.path),
+			merged)
+		if err != nil {
+			return "", fmt.Errorf("failed to open a pull request for the conflicting changes to %q: %v", f.path, err)
+		}
+		e.pullRequestURL = pr.GetHTMLURL()
+	}
+	return "", e
 }
 
 // --- Helper functions ---
 
+// pendingWriteKey is the key of the private state holding the pending write
+// of a file, if any.
+const pendingWriteKey = "pending_write"
+
+// privateStateGetter is implemented by the private state of requests.
+type privateStateGetter interface {
+	GetKey(ctx context.Context, key string) ([]byte, diag.Diagnostics)
+}
+
+// privateStateSetter is implemented by the private state of responses.
+type privateStateSetter interface {
+	SetKey(ctx context.Context, key string, value []byte) diag.Diagnostics
+}
+
+// getPendingWrite returns the pending write recorded in the given private
+// state, if any, so that the next write can resume or clean it up.
+func getPendingWrite(ctx context.Context, p privateStateGetter) (*pendingWrite, diag.Diagnostics) {
+	v, diags := p.GetKey(ctx, pendingWriteKey)
+	if diags.HasError() || len(v) == 0 {
+		return nil, diags
+	}
+	var w pendingWrite
+	if err := json.Unmarshal(v, &w); err != nil {
+		diags.AddError("Invalid private state", fmt.Sprintf("Failed to parse the pending write %q: %v", v, err))
+		return nil, diags
+	}
+	return &w, diags
+}
+
+// setPendingWrite records the given pending write in the given private
+// state, or removes it if there is none.
+func setPendingWrite(ctx context.Context, p privateStateSetter, w *pendingWrite) diag.Diagnostics {
+	if w == nil {
+		return p.SetKey(ctx, pendingWriteKey, nil)
+	}
+	v, err := json.Marshal(w)
+	if err != nil {
+		var diags diag.Diagnostics
+		diags.AddError("Failed to record pending write", err.Error())
+		return diags
+	}
+	return p.SetKey(ctx, pendingWriteKey, v)
+}
+
 func modelToFile(m *fileResourceModel) *file {
 	return &file{
-		repositoryOwner: m.RepositoryOwner.ValueString(),
-		repositoryName:  m.RepositoryName.ValueString(),
-		branch:          m.Branch.ValueString(),
-		path:            m.Path.ValueString(),
-		contents:        m.Contents.ValueString(),
+		repositoryOwner:     m.RepositoryOwner.ValueString(),
+		repositoryName:      m.RepositoryName.ValueString(),
+		branch:              m.Branch.ValueString(),
+		path:                m.Path.ValueString(),
+		contents:            m.Contents.ValueString(),
+		conflictPolicy:      m.ConflictPolicy.ValueString(),
+		conflictPullRequest: m.ConflictPullRequest.ValueBool(),
+		createBranch:        m.CreateBranch.ValueBool(),
+		baseBranch:          m.BaseBranch.ValueString(),
+		baseSHA:             m.BaseSHA.ValueString(),
+		orphanBranch:        m.OrphanBranch.ValueBool(),
+		blobSHA:             m.BlobSHA.ValueString(),
+		commitSHA:           m.CommitSHA.ValueString(),
+		htmlURL:             m.HTMLURL.ValueString(),
+		lastModifiedBy:      m.LastModifiedBy.ValueString(),
+		pullRequestNumber:   int(m.PullRequestNumber.ValueInt64()),
+		trackDefaultBranch:  tracksDefaultBranch(m.ID.ValueString()),
 	}
 }
 
 func fileToModel(f *file, m *fileResourceModel) {
-	m.ID = types.StringValue(fmt.Sprintf("%s/%s:%s:%s", f.repositoryOwner, f.repositoryName, f.branch, f.path))
+	m.ID = types.StringValue(fileID(f))
 	m.RepositoryOwner = types.StringValue(f.repositoryOwner)
 	m.RepositoryName = types.StringValue(f.repositoryName)
 	m.Branch = types.StringValue(f.branch)
 	m.Path = types.StringValue(f.path)
 	m.Contents = types.StringValue(f.contents)
+	m.BlobSHA = types.StringValue(f.blobSHA)
+	m.CommitSHA = types.StringValue(f.commitSHA)
+	m.PullRequestNumber = types.Int64Null()
+	if f.pullRequestNumber != 0 {
+		m.PullRequestNumber = types.Int64Value(int64(f.pullRequestNumber))
+	}
+	m.HTMLURL = types.StringValue(f.htmlURL)
+	m.LastModifiedBy = types.StringValue(f.lastModifiedBy)
+}
+
+// fileID returns the ID of the given file, in which the branch is left out if
+// the file tracks the default branch of its repository.
+func fileID(f *file) string {
+	b := f.branch
+	if f.trackDefaultBranch {
+		b = ""
+	}
+	return fmt.Sprintf("%s/%s:%s:%s", f.repositoryOwner, f.repositoryName, b, f.path)
+}
+
+// tracksDefaultBranch returns whether the given file ID is that of a file
+// which tracks the default branch of its repository.
+func tracksDefaultBranch(id string) bool {
+	_, _, b, _, err := parseFileID(id)
+	return err == nil && b == ""
 }
 
 func formatCommitMessage(p, m string, args ...interface{}) string {
@@ -16,17 +16,24 @@ package githubfile
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/form3tech-oss/go-github-utils/pkg/branch"
 	ghfileutils "github.com/form3tech-oss/go-github-utils/pkg/file"
 	"github.com/google/go-github/v54/github"
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
+	fwresource "github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
 	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
 	"github.com/hashicorp/terraform-plugin-testing/terraform"
 	"golang.org/x/oauth2"
@@ -141,6 +148,11 @@ func TestAccResourceFile_basic(t *testing.T) {
 					resource.TestCheckResourceAttr(resourceName, "branch", testBranchName),
 					resource.TestCheckResourceAttr(resourceName, "path", "foo/bar/baz/README.md"),
 					resource.TestCheckResourceAttr(resourceName, "contents", "foo\nbar\nbaz"),
+					resource.TestCheckResourceAttrSet(resourceName, "blob_sha"),
+					resource.TestCheckResourceAttrSet(resourceName, "commit_sha"),
+					resource.TestCheckResourceAttrSet(resourceName, "pull_request_number"),
+					resource.TestCheckResourceAttrSet(resourceName, "html_url"),
+					resource.TestCheckResourceAttrSet(resourceName, "last_modified_by"),
 				),
 			},
 			{
@@ -281,3 +293,386 @@ func TestDeleteFile_NonArchivedRepo_FileNotFound(t *testing.T) {
 		t.Fatalf("expected no error when file not found on non-archived repo, got: %v", err)
 	}
 }
+
+func TestDeleteFile_AbandonsPendingWrite(t *testing.T) {
+	var deleted bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":1,"name":"test-repo","archived":false}`)
+	})
+	// The file was never created, as its creation failed.
+	mux.HandleFunc("/repos/test-owner/test-repo/contents/some/file.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/pulls", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[]`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/git/refs/heads/work", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			deleted = true
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{
+		githubClient: newMockGitHubClient(server),
+	}
+	f := &file{
+		repositoryOwner: "test-owner",
+		repositoryName:  "test-repo",
+		branch:          "main",
+		path:            "some/file.txt",
+		pendingWrite:    &pendingWrite{Branch: "work"},
+	}
+
+	if err := deleteFile(context.Background(), config, f); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if !deleted {
+		t.Error("expected the working branch of the pending write to be deleted")
+	}
+	if f.pendingWrite != nil {
+		t.Errorf("expected no pending write to be left, got %+v", f.pendingWrite)
+	}
+}
+
+func TestCleanUpPullRequestBranch(t *testing.T) {
+	var closed, deleted bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo/pulls", func(w http.ResponseWriter, r *http.Request) {
+		if h := r.URL.Query().Get("head"); h != "test-owner:work" {
+			t.Errorf("expected pull requests from %q to be listed, got %q", "test-owner:work", h)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[{"number":7}]`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/pulls/7", func(w http.ResponseWriter, r *http.Request) {
+		closed = r.Method == http.MethodPatch
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"number":7,"state":"closed"}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/git/refs/heads/work", func(w http.ResponseWriter, r *http.Request) {
+		deleted = r.Method == http.MethodDelete
+		w.WriteHeader(http.StatusNoContent)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	f := &file{
+		repositoryOwner: "test-owner",
+		repositoryName:  "test-repo",
+		branch:          "main",
+		path:            "some/file.txt",
+	}
+
+	// Cleaning up must work even though the write's deadline has expired.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if p := cleanUpPullRequestBranch(ctx, newMockGitHubClient(server), f, "work"); p != nil {
+		t.Errorf("expected nothing to be left behind, got %+v", p)
+	}
+	if !closed {
+		t.Error("expected the pull request to be closed")
+	}
+	if !deleted {
+		t.Error("expected the branch to be deleted")
+	}
+}
+
+func TestReadOnly_RejectsWrites(t *testing.T) {
+	// Any request reaching the server means the write was not rejected up front.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to %s", r.URL.Path)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	config := &providerConfiguration{
+		githubClient: newMockGitHubClient(server),
+		readOnly:     true,
+	}
+
+	f := &file{
+		repositoryOwner: "test-owner",
+		repositoryName:  "test-repo",
+		branch:          "main",
+		path:            "some/file.txt",
+		contents:        "some content",
+	}
+
+	if err := createOrUpdateFile(context.Background(), config, f, "Create"); err != errReadOnly {
+		t.Errorf("expected create to be rejected with %v, got: %v", errReadOnly, err)
+	}
+	if err := deleteFile(context.Background(), config, f); err != errReadOnly {
+		t.Errorf("expected delete to be rejected with %v, got: %v", errReadOnly, err)
+	}
+}
+
+func TestReadOnly_RejectsPlannedWrites(t *testing.T) {
+	ctx := context.Background()
+	// Any request reaching the server means the change was not rejected
+	// before resolving anything.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to %s", r.URL.Path)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	r := &fileResource{config: &providerConfiguration{
+		githubClient: newMockGitHubClient(server),
+		readOnly:     true,
+	}}
+	var sr fwresource.SchemaResponse
+	r.Schema(ctx, fwresource.SchemaRequest{}, &sr)
+	typ := sr.Schema.Type().TerraformType(ctx)
+	null := tftypes.NewValue(typ, nil)
+
+	plan := tfsdk.Plan{Schema: sr.Schema, Raw: null}
+	m := fileResourceModel{
+		RepositoryOwner: types.StringValue("test-owner"),
+		RepositoryName:  types.StringValue("test-repo"),
+		Branch:          types.StringValue("main"),
+		Path:            types.StringValue("some/file.txt"),
+		Contents:        types.StringValue("some content"),
+		Timeouts:        timeouts.Value{Object: types.ObjectNull(sr.Schema.Blocks["timeouts"].Type().(timeouts.Type).AttrTypes)},
+	}
+	if diags := plan.Set(ctx, &m); diags.HasError() {
+		t.Fatalf("failed to build plan: %v", diags)
+	}
+	config := tfsdk.Config{Schema: sr.Schema, Raw: plan.Raw}
+
+	tests := map[string]fwresource.ModifyPlanRequest{
+		"create": {
+			Config: config,
+			Plan:   plan,
+			State:  tfsdk.State{Schema: sr.Schema, Raw: null},
+		},
+		"delete": {
+			Plan:  tfsdk.Plan{Schema: sr.Schema, Raw: null},
+			State: tfsdk.State{Schema: sr.Schema, Raw: plan.Raw},
+		},
+	}
+	for name, req := range tests {
+		resp := &fwresource.ModifyPlanResponse{Plan: req.Plan}
+		r.ModifyPlan(ctx, req, resp)
+		if !resp.Diagnostics.HasError() || resp.Diagnostics.Errors()[0].Summary() != "Provider Is Read-Only" {
+			t.Errorf("%s: expected the plan to be rejected as read-only, got: %v", name, resp.Diagnostics)
+		}
+	}
+}
+
+func TestReadFile_Metadata(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo/contents/some/file.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"type":"file","encoding":"base64","path":"some/file.txt","sha":"new-sha","html_url":"https://github.com/test-owner/test-repo/blob/main/some/file.txt","content":"Zm9v"}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/commits", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[{"sha":"commit-sha","author":{"login":"ci-bot"},"commit":{"author":{"name":"CI Bot"}}}]`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/commits/commit-sha/pulls", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[{"number":42}]`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{
+		githubClient: newMockGitHubClient(server),
+	}
+
+	f := &file{
+		repositoryOwner: "test-owner",
+		repositoryName:  "test-repo",
+		branch:          "main",
+		path:            "some/file.txt",
+		blobSHA:         "old-sha",
+	}
+
+	if err := readFile(context.Background(), config, f); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if f.contents != "foo" {
+		t.Errorf("expected contents %q, got %q", "foo", f.contents)
+	}
+	if f.blobSHA != "new-sha" {
+		t.Errorf("expected blob SHA %q, got %q", "new-sha", f.blobSHA)
+	}
+	if f.commitSHA != "commit-sha" {
+		t.Errorf("expected commit SHA %q, got %q", "commit-sha", f.commitSHA)
+	}
+	if f.pullRequestNumber != 42 {
+		t.Errorf("expected pull request number %d, got %d", 42, f.pullRequestNumber)
+	}
+	if f.lastModifiedBy != "ci-bot" {
+		t.Errorf("expected last modified by %q, got %q", "ci-bot", f.lastModifiedBy)
+	}
+}
+
+func TestReadFile_UnchangedBlob(t *testing.T) {
+	// Only the contents endpoint is served, so any attempt to look up the
+	// commit metadata again fails the test.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo/contents/some/file.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"type":"file","encoding":"base64","path":"some/file.txt","sha":"blob-sha","content":"Zm9v"}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{
+		githubClient: newMockGitHubClient(server),
+	}
+
+	f := &file{
+		repositoryOwner: "test-owner",
+		repositoryName:  "test-repo",
+		branch:          "main",
+		path:            "some/file.txt",
+		contents:        "foo",
+		blobSHA:         "blob-sha",
+		commitSHA:       "commit-sha",
+	}
+
+	if err := readFile(context.Background(), config, f); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if f.commitSHA != "commit-sha" {
+		t.Errorf("expected commit SHA %q, got %q", "commit-sha", f.commitSHA)
+	}
+}
+
+func TestCreateOrUpdateFile_Conflict(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo/contents/a.txt", func(w http.ResponseWriter, r *http.Request) {
+		// "a\nedited\n", as changed by someone outside of Terraform.
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"type":"file","path":"a.txt","sha":%q,"encoding":"base64","content":"YQplZGl0ZWQK"}`, gitBlobSHA("a\nedited\n"))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{
+		githubClient: newMockGitHubClient(server),
+	}
+	f := &file{
+		repositoryOwner: "test-owner",
+		repositoryName:  "test-repo",
+		branch:          "main",
+		path:            "a.txt",
+		contents:        "a\nb\nc\n",
+		conflictPolicy:  conflictPolicyFail,
+		knownBlobSHA:    gitBlobSHA("a\nb\n"),
+		knownContents:   "a\nb\n",
+	}
+
+	err := createOrUpdateFile(context.Background(), config, f, "Update %q.")
+	var ce *conflictError
+	if !errors.As(err, &ce) {
+		t.Fatalf("expected a conflict error, got: %v", err)
+	}
+	if expected := "-b\n+edited\n"; !strings.Contains(ce.diff, expected) {
+		t.Errorf("expected the diff to contain %q, got:\n%s", expected, ce.diff)
+	}
+}
+
+func TestCreateOrUpdateFile_MergeConflict(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo/contents/a.txt", func(w http.ResponseWriter, r *http.Request) {
+		// "a\nremote\nc\n", as changed by someone outside of Terraform.
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"type":"file","path":"a.txt","sha":%q,"encoding":"base64","content":"YQpyZW1vdGUKYwo="}`, gitBlobSHA("a\nremote\nc\n"))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{
+		githubClient: newMockGitHubClient(server),
+	}
+	f := &file{
+		repositoryOwner: "test-owner",
+		repositoryName:  "test-repo",
+		branch:          "main",
+		path:            "a.txt",
+		contents:        "a\nterraform\nc\n",
+		conflictPolicy:  conflictPolicyMerge,
+		knownBlobSHA:    gitBlobSHA("a\nb\nc\n"),
+		knownContents:   "a\nb\nc\n",
+	}
+
+	err := createOrUpdateFile(context.Background(), config, f, "Update %q.")
+	var mce *mergeConflictError
+	if !errors.As(err, &mce) {
+		t.Fatalf("expected a merge conflict error, got: %v", err)
+	}
+	if expected := "<<<<<<< remote\nremote\n=======\nterraform\n>>>>>>> terraform\n"; len(mce.conflicts) != 1 || mce.conflicts[0] != expected {
+		t.Errorf("expected the conflict %q, got %q", expected, mce.conflicts)
+	}
+}
+
+func TestCheckPathIsNotDirectory(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo/contents/docs", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[{"type":"file","path":"docs/a.md"}]`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/contents/a.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{
+		githubClient: newMockGitHubClient(server),
+	}
+	f := &file{repositoryOwner: "test-owner", repositoryName: "test-repo", branch: "main", path: "docs"}
+	if err := checkPathIsNotDirectory(context.Background(), config, f); err == nil {
+		t.Error("expected an error for a directory")
+	}
+	f.path = "a.txt"
+	if err := checkPathIsNotDirectory(context.Background(), config, f); err != nil {
+		t.Errorf("expected no error for a missing file, got: %v", err)
+	}
+}
+
+func TestResolveDefaultBranch(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"default_branch":"main"}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{githubClient: newMockGitHubClient(server)}
+	f := &file{repositoryOwner: "test-owner", repositoryName: "test-repo", path: "a.txt", trackDefaultBranch: true}
+	if err := resolveDefaultBranch(context.Background(), config, f); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if f.branch != "main" {
+		t.Errorf("expected the default branch to be resolved, got %q", f.branch)
+	}
+	id := fileID(f)
+	if expected := "test-owner/test-repo::a.txt"; id != expected {
+		t.Errorf("expected the ID to leave the branch out, got %q", id)
+	}
+	if !tracksDefaultBranch(id) {
+		t.Errorf("expected %q to track the default branch", id)
+	}
+	if tracksDefaultBranch("test-owner/test-repo:main:a.txt") {
+		t.Error("expected an ID with a branch not to track the default branch")
+	}
+}
@@ -0,0 +1,331 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+const (
+	defaultRetryMaxAttempts    = 3
+	defaultRetryInitialBackoff = 5 * time.Second
+	defaultRetryMaxBackoff     = time.Minute
+)
+
+var (
+	// contentAddressedPathRegexp matches the paths of the endpoints creating
+	// git objects, which are identified by their contents, so creating them
+	// again after an unknown outcome is harmless.
+	contentAddressedPathRegexp = regexp.MustCompile(`/repos/[^/]+/[^/]+/git/(blobs|commits|trees)$`)
+	// mergePathRegexp matches the path of the endpoint merging a pull
+	// request, capturing its number.
+	mergePathRegexp = regexp.MustCompile(`/repos/[^/]+/[^/]+/pulls/(\d+)/merge$`)
+	// refsPathRegexp matches the path of the endpoint creating refs.
+	refsPathRegexp = regexp.MustCompile(`/repos/[^/]+/[^/]+/git/refs$`)
+	// pullsPathRegexp matches the path of the endpoint creating pull
+	// requests, capturing the repository owner.
+	pullsPathRegexp = regexp.MustCompile(`/repos/([^/]+)/[^/]+/pulls$`)
+)
+
+// retryModel describes the "retry" block of the provider.
+type retryModel struct {
+	InitialBackoff types.String `tfsdk:"initial_backoff"`
+	Jitter         types.Bool   `tfsdk:"jitter"`
+	MaxAttempts    types.Int64  `tfsdk:"max_attempts"`
+	MaxBackoff     types.String `tfsdk:"max_backoff"`
+}
+
+// retryPolicy describes how many times and how often to retry failed
+// requests to GitHub.
+type retryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	jitter         bool
+}
+
+// newRetryPolicy returns the retry policy configured by the given "retry"
+// block, if any.
+func newRetryPolicy(m *retryModel) (*retryPolicy, error) {
+	p := &retryPolicy{
+		maxAttempts:    defaultRetryMaxAttempts,
+		initialBackoff: defaultRetryInitialBackoff,
+		maxBackoff:     defaultRetryMaxBackoff,
+		jitter:         true,
+	}
+	if m == nil {
+		return p, nil
+	}
+	if !m.MaxAttempts.IsNull() && !m.MaxAttempts.IsUnknown() {
+		if m.MaxAttempts.ValueInt64() < 1 {
+			return nil, fmt.Errorf("max_attempts must be at least 1, got %d", m.MaxAttempts.ValueInt64())
+		}
+		p.maxAttempts = int(m.MaxAttempts.ValueInt64())
+	}
+	for _, v := range []struct {
+		name  string
+		value types.String
+		d     *time.Duration
+	}{
+		{"initial_backoff", m.InitialBackoff, &p.initialBackoff},
+		{"max_backoff", m.MaxBackoff, &p.maxBackoff},
+	} {
+		if v.value.ValueString() == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v.value.ValueString())
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s %q as a duration: %v", v.name, v.value.ValueString(), err)
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("%s must not be negative, got %q", v.name, v.value.ValueString())
+		}
+		*v.d = d
+	}
+	if p.initialBackoff > p.maxBackoff {
+		return nil, fmt.Errorf("initial_backoff (%s) must not be greater than max_backoff (%s)", p.initialBackoff, p.maxBackoff)
+	}
+	if !m.Jitter.IsNull() && !m.Jitter.IsUnknown() {
+		p.jitter = m.Jitter.ValueBool()
+	}
+	return p, nil
+}
+
+// backoff returns how long to wait before the given retry, counting from 1.
+// The wait doubles with each retry up to the maximum and, with jitter, is
+// picked at random between half of that and all of it.
+func (p *retryPolicy) backoff(retry int) time.Duration {
+	d := p.initialBackoff
+	for i := 1; i < retry && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	if p.jitter && d > 1 {
+		d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1)) //nolint:gosec
+	}
+	return d
+}
+
+// retryTransport is an http.RoundTripper that retries requests to GitHub
+// which fail in a way that may succeed if tried again.
+type retryTransport struct {
+	base   http.RoundTripper
+	policy *retryPolicy
+	// sleep waits for the given duration, or until the context is done.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// newRetryTransport returns a retryTransport sending requests through base
+// and retrying them according to the given policy.
+func newRetryTransport(base http.RoundTripper, policy *retryPolicy) *retryTransport {
+	return &retryTransport{
+		base:   base,
+		policy: policy,
+		sleep:  sleepContext,
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if attempt > 1 && err == nil && resp.StatusCode == http.StatusUnprocessableEntity {
+			if v := t.recoverCreate(req, resp); v != nil {
+				resp.Body.Close()
+				return v, nil
+			}
+		}
+		if attempt >= t.policy.maxAttempts || !isRetryable(req, resp, err) || ctx.Err() != nil {
+			return resp, err
+		}
+		// Requests whose body cannot be read again cannot be retried.
+		if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			return resp, err
+		}
+
+		d := t.policy.backoff(attempt)
+		fields := map[string]interface{}{
+			"method":  req.Method,
+			"path":    req.URL.Path,
+			"attempt": attempt,
+			"wait":    d.String(),
+		}
+		if err != nil {
+			fields["error"] = err.Error()
+		} else {
+			fields["status"] = resp.StatusCode
+			io.Copy(io.Discard, resp.Body) //nolint:errcheck
+			resp.Body.Close()
+		}
+		tflog.Warn(ctx, "GitHub request failed, retrying", fields)
+		if err := t.sleep(ctx, d); err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			b, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = b
+		}
+	}
+}
+
+// isRetryable returns whether the given request may succeed if sent again
+// after failing with the given response or error.
+//
+// Server errors and network errors are retried for requests which are safe
+// to repeat even if the failed attempt took effect: those with idempotent
+// methods, those creating content-addressed git objects, and those creating
+// refs or pull requests, whose repetition failing because the failed attempt
+// took effect is turned into success by recoverCreate. Merging a pull
+// request is also retried when GitHub refuses to because the base branch has
+// just been modified, but not when the pull request cannot be merged.
+func isRetryable(req *http.Request, resp *http.Response, err error) bool {
+	safe := isIdempotentMethod(req.Method) ||
+		(req.Method == http.MethodPost && (contentAddressedPathRegexp.MatchString(req.URL.Path) ||
+			refsPathRegexp.MatchString(req.URL.Path) || pullsPathRegexp.MatchString(req.URL.Path)))
+	if err != nil {
+		return safe
+	}
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return safe
+	case http.StatusMethodNotAllowed:
+		return req.Method == http.MethodPut && mergePathRegexp.MatchString(req.URL.Path) &&
+			strings.Contains(strings.ToLower(peekBody(resp)), "base branch was modified")
+	}
+	return false
+}
+
+// recoverCreate returns a successful response for the retried request
+// creating a ref or pull request which failed with the given response
+// because the ref or pull request already exists, as created by an earlier
+// attempt whose outcome was unknown. The ref must point at the requested
+// commit, and the pull request be the only open one from the requested
+// branch, for them to be taken as created by that attempt. It returns nil
+// otherwise.
+func (t *retryTransport) recoverCreate(req *http.Request, resp *http.Response) *http.Response {
+	if req.Method != http.MethodPost || req.GetBody == nil || !strings.Contains(strings.ToLower(peekBody(resp)), "already exists") {
+		return nil
+	}
+	b, err := req.GetBody()
+	if err != nil {
+		return nil
+	}
+	defer b.Close()
+	var v struct {
+		Ref  string `json:"ref"`
+		SHA  string `json:"sha"`
+		Head string `json:"head"`
+		Base string `json:"base"`
+	}
+	if json.NewDecoder(b).Decode(&v) != nil {
+		return nil
+	}
+
+	u := *req.URL
+	switch {
+	case refsPathRegexp.MatchString(req.URL.Path):
+		u.Path = strings.TrimSuffix(u.Path, "/refs") + "/ref/" + strings.TrimPrefix(v.Ref, "refs/")
+		u.RawPath = ""
+	case pullsPathRegexp.MatchString(req.URL.Path):
+		head := v.Head
+		if !strings.Contains(head, ":") {
+			head = pullsPathRegexp.FindStringSubmatch(req.URL.Path)[1] + ":" + head
+		}
+		u.RawQuery = url.Values{"head": {head}, "base": {v.Base}, "state": {"open"}}.Encode()
+	default:
+		return nil
+	}
+	g, err := http.NewRequestWithContext(req.Context(), http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil
+	}
+	g.Header = req.Header.Clone()
+	g.Header.Del("Content-Type")
+	r, err := t.base.RoundTrip(g)
+	if err != nil {
+		return nil
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil || r.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	if v.Ref != "" {
+		var ref struct {
+			Object struct {
+				SHA string `json:"sha"`
+			} `json:"object"`
+		}
+		if json.Unmarshal(body, &ref) != nil || ref.Object.SHA != v.SHA {
+			return nil
+		}
+	} else {
+		var prs []json.RawMessage
+		if json.Unmarshal(body, &prs) != nil || len(prs) != 1 {
+			return nil
+		}
+		body = prs[0]
+	}
+	r.StatusCode = http.StatusCreated
+	r.Status = "201 Created"
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	r.Request = req
+	return r
+}
+
+// peekBody returns the body of the given response, leaving it to be read
+// again.
+func peekBody(resp *http.Response) string {
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// isIdempotentMethod returns whether sending a request with the given method
+// several times has the same effect as sending it once.
+func isIdempotentMethod(m string) bool {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
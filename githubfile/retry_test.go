@@ -0,0 +1,260 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name             string
+		method           string
+		path             string
+		status           int
+		body             string
+		expectedRequests int
+	}{
+		{
+			name:             "get server error",
+			method:           http.MethodGet,
+			path:             "/repos/o/r/contents/a.txt",
+			status:           http.StatusBadGateway,
+			expectedRequests: 3,
+		},
+		{
+			name:             "create tree server error",
+			method:           http.MethodPost,
+			path:             "/repos/o/r/git/trees",
+			status:           http.StatusInternalServerError,
+			expectedRequests: 3,
+		},
+		{
+			name:             "create ref server error",
+			method:           http.MethodPost,
+			path:             "/repos/o/r/git/refs",
+			status:           http.StatusInternalServerError,
+			expectedRequests: 3,
+		},
+		{
+			name:             "create pull request server error",
+			method:           http.MethodPost,
+			path:             "/repos/o/r/pulls",
+			status:           http.StatusBadGateway,
+			expectedRequests: 3,
+		},
+		{
+			name:             "create pull request review server error",
+			method:           http.MethodPost,
+			path:             "/repos/o/r/pulls/1/reviews",
+			status:           http.StatusBadGateway,
+			expectedRequests: 1,
+		},
+		{
+			name:             "merge base branch modified",
+			method:           http.MethodPut,
+			path:             "/repos/o/r/pulls/1/merge",
+			status:           http.StatusMethodNotAllowed,
+			body:             `{"message":"Base branch was modified. Review and try the merge again."}`,
+			expectedRequests: 3,
+		},
+		{
+			name:             "merge blocked",
+			method:           http.MethodPut,
+			path:             "/repos/o/r/pulls/1/merge",
+			status:           http.StatusMethodNotAllowed,
+			body:             `{"message":"At least 1 approving review is required by reviewers with write access."}`,
+			expectedRequests: 1,
+		},
+		{
+			name:             "not found",
+			method:           http.MethodGet,
+			path:             "/repos/o/r/contents/a.txt",
+			status:           http.StatusNotFound,
+			expectedRequests: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if b, _ := io.ReadAll(r.Body); r.Method != http.MethodGet && string(b) != "{}" {
+					t.Errorf("expected the request body to be sent on every attempt, got %q", b)
+				}
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer server.Close()
+
+			var waits []time.Duration
+			rt := newRetryTransport(http.DefaultTransport, &retryPolicy{maxAttempts: 3, initialBackoff: time.Second, maxBackoff: time.Minute})
+			rt.sleep = func(_ context.Context, d time.Duration) error {
+				waits = append(waits, d)
+				return nil
+			}
+
+			var body io.Reader
+			if tt.method != http.MethodGet {
+				body = strings.NewReader("{}")
+			}
+			req, _ := http.NewRequest(tt.method, server.URL+tt.path, body)
+			resp, err := rt.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(b) != tt.body {
+				t.Errorf("expected body %q, got %q", tt.body, b)
+			}
+			if resp.StatusCode != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, resp.StatusCode)
+			}
+			if requests != tt.expectedRequests {
+				t.Errorf("expected %d requests, got %d", tt.expectedRequests, requests)
+			}
+			if len(waits) != tt.expectedRequests-1 {
+				t.Errorf("expected %d waits, got %v", tt.expectedRequests-1, waits)
+			}
+		})
+	}
+}
+
+func TestRetryTransport_RecoversCreate(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		existing string
+		status   int
+	}{
+		{
+			name:     "ref at the requested commit",
+			path:     "/repos/o/r/git/refs",
+			body:     `{"ref":"refs/heads/work","sha":"abc"}`,
+			existing: `{"ref":"refs/heads/work","object":{"sha":"abc"}}`,
+			status:   http.StatusCreated,
+		},
+		{
+			name:     "ref at another commit",
+			path:     "/repos/o/r/git/refs",
+			body:     `{"ref":"refs/heads/work","sha":"abc"}`,
+			existing: `{"ref":"refs/heads/work","object":{"sha":"def"}}`,
+			status:   http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "pull request",
+			path:     "/repos/o/r/pulls",
+			body:     `{"title":"t","head":"work","base":"main"}`,
+			existing: `[{"number":7,"head":{"ref":"work"}}]`,
+			status:   http.StatusCreated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var creates int
+			mux := http.NewServeMux()
+			mux.HandleFunc(tt.path, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost {
+					if v := r.URL.Query().Get("head"); v != "o:work" {
+						t.Errorf("expected pull requests from %q to be listed, got %q", "o:work", v)
+					}
+					fmt.Fprint(w, tt.existing)
+					return
+				}
+				creates++
+				// The first attempt takes effect, but its response is lost.
+				if creates == 1 {
+					w.WriteHeader(http.StatusBadGateway)
+					return
+				}
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				fmt.Fprint(w, `{"message":"Reference already exists"}`)
+			})
+			mux.HandleFunc("/repos/o/r/git/ref/heads/work", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, tt.existing)
+			})
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			rt := newRetryTransport(http.DefaultTransport, &retryPolicy{maxAttempts: 3, initialBackoff: time.Second, maxBackoff: time.Minute})
+			rt.sleep = func(_ context.Context, _ time.Duration) error { return nil }
+
+			req, _ := http.NewRequest(http.MethodPost, server.URL+tt.path, strings.NewReader(tt.body))
+			resp, err := rt.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, resp.StatusCode)
+			}
+			if creates != 2 {
+				t.Errorf("expected 2 attempts, got %d", creates)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := &retryPolicy{maxAttempts: 5, initialBackoff: time.Second, maxBackoff: 5 * time.Second}
+	for i, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		if d := p.backoff(i + 1); d != expected {
+			t.Errorf("expected retry %d to wait %v, got %v", i+1, expected, d)
+		}
+	}
+
+	p.jitter = true
+	for i := 0; i < 100; i++ {
+		if d := p.backoff(2); d < time.Second || d > 2*time.Second {
+			t.Fatalf("expected jittered wait between 1s and 2s, got %v", d)
+		}
+	}
+}
+
+func TestNewRetryPolicy(t *testing.T) {
+	p, err := newRetryPolicy(&retryModel{
+		InitialBackoff: types.StringValue("2s"),
+		Jitter:         types.BoolValue(false),
+		MaxAttempts:    types.Int64Value(5),
+		MaxBackoff:     types.StringNull(),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	expected := retryPolicy{maxAttempts: 5, initialBackoff: 2 * time.Second, maxBackoff: defaultRetryMaxBackoff}
+	if *p != expected {
+		t.Errorf("expected %+v, got %+v", expected, *p)
+	}
+
+	if _, err := newRetryPolicy(&retryModel{
+		InitialBackoff: types.StringValue("2m"),
+		Jitter:         types.BoolNull(),
+		MaxAttempts:    types.Int64Null(),
+		MaxBackoff:     types.StringValue("1m"),
+	}); err == nil {
+		t.Error("expected an initial backoff greater than the maximum to be rejected")
+	}
+}
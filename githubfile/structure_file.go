@@ -20,11 +20,47 @@ import (
 )
 
 type file struct {
+	repositoryOwner   string
+	repositoryName    string
+	branch            string
+	path              string
+	contents          string
+	blobSHA           string
+	commitSHA         string
+	pullRequestNumber int
+	htmlURL           string
+	lastModifiedBy    string
+	pendingWrite      *pendingWrite
+	conflictPolicy    string
+	// conflictPullRequest is whether to open a pull request for conflicts
+	// found when merging.
+	conflictPullRequest bool
+	// trackDefaultBranch is whether the file is in the default branch of its
+	// repository because no branch was given.
+	trackDefaultBranch bool
+	// createBranch is whether to create the branch if it does not exist,
+	// from baseSHA, from the head of baseBranch, or as an orphan branch.
+	createBranch bool
+	baseBranch   string
+	baseSHA      string
+	orphanBranch bool
+	// knownBlobSHA and knownContents describe the file as Terraform last
+	// knew it, before the write in progress. When merging, knownContents are
+	// the contents last applied.
+	knownBlobSHA  string
+	knownContents string
+}
+
+type remoteFile struct {
 	repositoryOwner string
 	repositoryName  string
-	branch          string
+	ref             string
 	path            string
 	contents        string
+	blobSHA         string
+	size            int
+	mode            string
+	commitSHA       string
 }
 
 func parseFileID(v string) (string, string, string, string, error) {
@@ -0,0 +1,145 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/form3tech-oss/go-github-utils/pkg/branch"
+	"github.com/google/go-github/v54/github"
+)
+
+// emptyTreeSHA is the SHA of the empty git tree, which every repository has.
+const emptyTreeSHA = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// ensureBranch creates the file's branch if it does not exist and the file
+// asks for it, from the file's base SHA, from the head of its base branch,
+// which defaults to the repository's default branch, or as an orphan branch
+// whose only commit has an empty tree.
+func ensureBranch(ctx context.Context, c *providerConfiguration, f *file) error {
+	if !f.createBranch {
+		return nil
+	}
+	gc := c.client(f.repositoryOwner)
+	_, res, err := gc.Git.GetRef(ctx, f.repositoryOwner, f.repositoryName, "heads/"+f.branch)
+	// An empty repository gets the branch along with its first commit.
+	if err == nil || isEmptyRepository(err) {
+		return nil
+	}
+	if res == nil || res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("failed to look up branch %q: %v", f.branch, err)
+	}
+
+	s := f.baseSHA
+	switch {
+	case f.orphanBranch:
+		v, err := createCommit(ctx, c, f, formatCommitMessage(c.commitMessagePrefix, "Create branch %q.", f.branch), emptyTreeSHA)
+		if err != nil {
+			return err
+		}
+		s = v.GetSHA()
+	case s == "":
+		b := f.baseBranch
+		if b == "" {
+			if b, err = branch.GetDefaultBranch(ctx, gc, f.repositoryOwner, f.repositoryName); err != nil {
+				return fmt.Errorf("failed to look up the default branch of %s/%s: %v", f.repositoryOwner, f.repositoryName, err)
+			}
+		}
+		if s, err = branch.GetSHAForBranch(ctx, gc, f.repositoryOwner, f.repositoryName, b); err != nil {
+			return fmt.Errorf("failed to look up base branch %q: %v", b, err)
+		}
+	}
+
+	log.Printf("[INFO] Creating branch %q of %s/%s at %s", f.branch, f.repositoryOwner, f.repositoryName, s)
+	if _, _, err := gc.Git.CreateRef(ctx, f.repositoryOwner, f.repositoryName, &github.Reference{
+		Ref:    github.String("refs/heads/" + f.branch),
+		Object: &github.GitObject{SHA: github.String(s)},
+	}); err != nil {
+		// The branch may have just been created for another file.
+		if _, _, gerr := gc.Git.GetRef(ctx, f.repositoryOwner, f.repositoryName, "heads/"+f.branch); gerr == nil {
+			return nil
+		}
+		return fmt.Errorf("failed to create branch %q: %v", f.branch, err)
+	}
+	return nil
+}
+
+// isEmptyRepository returns whether the given error from the Git database
+// API says that the repository has no commits yet.
+func isEmptyRepository(err error) bool {
+	var e *github.ErrorResponse
+	return errors.As(err, &e) && e.Response != nil && e.Response.StatusCode == http.StatusConflict &&
+		strings.Contains(strings.ToLower(e.Message), "empty")
+}
+
+// createInitialCommit makes the given changes as the first commit of the
+// file's empty repository, creating the file's branch at it. There is no
+// branch to open a pull request against, so the commit is made directly.
+func createInitialCommit(ctx context.Context, c *providerConfiguration, f *file, message string, changes []*github.TreeEntry) error {
+	gc := c.client(f.repositoryOwner)
+	log.Printf("[INFO] Creating the first commit of empty repository %s/%s in branch %q", f.repositoryOwner, f.repositoryName, f.branch)
+	tree, _, err := gc.Git.CreateTree(ctx, f.repositoryOwner, f.repositoryName, "", changes)
+	if err != nil {
+		if isEmptyRepository(err) && len(changes) == 1 {
+			// GitHub may refuse to create git objects in an empty repository,
+			// which only the contents API can then initialise.
+			return createInitialFile(ctx, c, f, message, changes[0])
+		}
+		return fmt.Errorf("failed to create tree: %v", err)
+	}
+	commit, err := createCommit(ctx, c, f, message, tree.GetSHA())
+	if err != nil {
+		return err
+	}
+	if _, _, err := gc.Git.CreateRef(ctx, f.repositoryOwner, f.repositoryName, &github.Reference{
+		Ref:    github.String("refs/heads/" + f.branch),
+		Object: &github.GitObject{SHA: commit.SHA},
+	}); err != nil {
+		return fmt.Errorf("failed to create branch %q: %v", f.branch, err)
+	}
+	return nil
+}
+
+// createInitialFile writes the file as the first commit of its empty
+// repository through the contents API. Commits made this way cannot be signed
+// with the configured GPG key.
+func createInitialFile(ctx context.Context, c *providerConfiguration, f *file, message string, e *github.TreeEntry) error {
+	if c.gpgSecretKey != "" {
+		log.Printf("[WARN] The first commit of %s/%s is made through the contents API, so it is not signed with the configured GPG key", f.repositoryOwner, f.repositoryName)
+	}
+	username, email, err := c.commitIdentity(ctx, f.repositoryOwner)
+	if err != nil {
+		return err
+	}
+	author := &github.CommitAuthor{
+		Name:  github.String(username),
+		Email: github.String(email),
+	}
+	if _, _, err := c.client(f.repositoryOwner).Repositories.CreateFile(ctx, f.repositoryOwner, f.repositoryName, e.GetPath(), &github.RepositoryContentFileOptions{
+		Message:   github.String(message),
+		Content:   []byte(e.GetContent()),
+		Branch:    github.String(f.branch),
+		Author:    author,
+		Committer: author,
+	}); err != nil {
+		return fmt.Errorf("failed to create %q: %v", e.GetPath(), err)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newMissingBranchServer returns a server on which branch "release" of
+// test-owner/test-repo does not exist, recording the SHA it is created at.
+func newMissingBranchServer(t *testing.T, createdAt *string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo/git/ref/heads/release", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/git/refs", func(w http.ResponseWriter, r *http.Request) {
+		var v struct {
+			Ref string `json:"ref"`
+			SHA string `json:"sha"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v.Ref != "refs/heads/release" {
+			t.Errorf("expected branch release to be created, got %q", v.Ref)
+		}
+		*createdAt = v.SHA
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"ref":"refs/heads/release"}`)
+	})
+	return mux
+}
+
+func TestEnsureBranch_FromDefaultBranch(t *testing.T) {
+	var createdAt string
+	mux := newMissingBranchServer(t, &createdAt)
+	mux.HandleFunc("/repos/test-owner/test-repo", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"default_branch":"main"}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/git/ref/heads/main", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"ref":"refs/heads/main","object":{"sha":"main-sha"}}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{githubClient: newMockGitHubClient(server)}
+	f := &file{repositoryOwner: "test-owner", repositoryName: "test-repo", branch: "release", path: "a.txt", createBranch: true}
+	if err := ensureBranch(context.Background(), config, f); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if createdAt != "main-sha" {
+		t.Errorf("expected the branch to be created at the head of main, got %q", createdAt)
+	}
+}
+
+func TestEnsureBranch_Orphan(t *testing.T) {
+	var createdAt string
+	mux := newMissingBranchServer(t, &createdAt)
+	mux.HandleFunc("/repos/test-owner/test-repo/git/commits", func(w http.ResponseWriter, r *http.Request) {
+		var v struct {
+			Tree    string   `json:"tree"`
+			Parents []string `json:"parents"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v.Tree != emptyTreeSHA || len(v.Parents) != 0 {
+			t.Errorf("expected a root commit of the empty tree, got tree %q and parents %q", v.Tree, v.Parents)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"sha":"root-sha"}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{githubClient: newMockGitHubClient(server)}
+	f := &file{repositoryOwner: "test-owner", repositoryName: "test-repo", branch: "release", path: "a.txt", createBranch: true, orphanBranch: true}
+	if err := ensureBranch(context.Background(), config, f); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if createdAt != "root-sha" {
+		t.Errorf("expected the branch to be created at the root commit, got %q", createdAt)
+	}
+}
+
+// newEmptyRepositoryServer returns a server for test-owner/test-repo having
+// no commits yet, on which writing a.txt through the contents API is handled
+// by put.
+func newEmptyRepositoryServer(put http.HandlerFunc) *http.ServeMux {
+	empty := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, `{"message":"Git Repository is empty."}`)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo/contents/a.txt", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && put != nil {
+			put(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/pulls", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[]`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/git/refs/heads/", empty)
+	mux.HandleFunc("/repos/test-owner/test-repo/git/ref/heads/main", empty)
+	return mux
+}
+
+func TestCreateOrUpdateFile_EmptyRepository(t *testing.T) {
+	var createdAt string
+	mux := newEmptyRepositoryServer(nil)
+	mux.HandleFunc("/repos/test-owner/test-repo/git/trees", func(w http.ResponseWriter, r *http.Request) {
+		var v struct {
+			BaseTree string `json:"base_tree"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v.BaseTree != "" {
+			t.Errorf("expected a fresh tree, got base tree %q", v.BaseTree)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"sha":"tree-sha"}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/git/commits", func(w http.ResponseWriter, r *http.Request) {
+		var v struct {
+			Parents []string `json:"parents"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if len(v.Parents) != 0 {
+			t.Errorf("expected a root commit, got parents %q", v.Parents)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"sha":"root-sha"}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/git/refs", func(w http.ResponseWriter, r *http.Request) {
+		var v struct {
+			Ref string `json:"ref"`
+			SHA string `json:"sha"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v.Ref != "refs/heads/main" {
+			t.Errorf("expected branch main to be created, got %q", v.Ref)
+		}
+		createdAt = v.SHA
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"ref":"refs/heads/main"}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{branchPrefix: "tf-", githubClient: newMockGitHubClient(server)}
+	f := &file{repositoryOwner: "test-owner", repositoryName: "test-repo", branch: "main", path: "a.txt", contents: "a\n"}
+	if err := createOrUpdateFile(context.Background(), config, f, "Create %q."); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if createdAt != "root-sha" {
+		t.Errorf("expected the branch to be created at the root commit, got %q", createdAt)
+	}
+}
+
+func TestCreateOrUpdateFile_EmptyRepositoryContentsAPI(t *testing.T) {
+	var created bool
+	mux := newEmptyRepositoryServer(func(w http.ResponseWriter, r *http.Request) {
+		var v struct {
+			Branch string `json:"branch"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		created = v.Branch == "main"
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"content":{"path":"a.txt"}}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/git/trees", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, `{"message":"Git Repository is empty."}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{branchPrefix: "tf-", githubClient: newMockGitHubClient(server)}
+	f := &file{repositoryOwner: "test-owner", repositoryName: "test-repo", branch: "main", path: "a.txt", contents: "a\n"}
+	if err := createOrUpdateFile(context.Background(), config, f, "Create %q."); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if !created {
+		t.Error("expected the file to be created in branch main through the contents API")
+	}
+}
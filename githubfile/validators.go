@@ -0,0 +1,202 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+)
+
+var (
+	// ownerPattern matches GitHub user and organisation names of at most 39
+	// characters, allowing the underscores of Enterprise Managed Users.
+	ownerPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_-]{0,37}[A-Za-z0-9])?$`)
+	// repositoryNamePattern matches the names GitHub allows repositories.
+	repositoryNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,100}$`)
+)
+
+// stringValidator validates string attributes with a function returning an
+// error describing why a value is invalid.
+type stringValidator struct {
+	description string
+	validate    func(string) error
+}
+
+var _ validator.String = stringValidator{}
+
+func (v stringValidator) Description(_ context.Context) string {
+	return v.description
+}
+
+func (v stringValidator) MarkdownDescription(ctx context.Context) string {
+	return v.Description(ctx)
+}
+
+func (v stringValidator) ValidateString(_ context.Context, req validator.StringRequest, resp *validator.StringResponse) {
+	if req.ConfigValue.IsNull() || req.ConfigValue.IsUnknown() {
+		return
+	}
+	if err := v.validate(req.ConfigValue.ValueString()); err != nil {
+		resp.Diagnostics.AddAttributeError(req.Path, "Invalid Attribute Value", err.Error())
+	}
+}
+
+func ownerValidator() validator.String {
+	return stringValidator{"must be a valid GitHub user or organisation name", validateOwner}
+}
+
+func repositoryNameValidator() validator.String {
+	return stringValidator{"must be a valid GitHub repository name", validateRepositoryName}
+}
+
+func branchValidator() validator.String {
+	return stringValidator{"must be a valid git branch name", validateBranch}
+}
+
+func filePathValidator() validator.String {
+	return stringValidator{"must be a relative path to a file in the repository", validateFilePath}
+}
+
+// oneOfValidator validates that a string attribute is one of the given
+// values.
+func oneOfValidator(values ...string) validator.String {
+	return stringValidator{fmt.Sprintf("must be one of %s", quoteList(values)), validateOneOf(values...)}
+}
+
+func validateOneOf(values ...string) func(string) error {
+	return func(v string) error {
+		for _, w := range values {
+			if v == w {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not valid: it must be one of %s", v, quoteList(values))
+	}
+}
+
+// quoteList returns the given values quoted and joined as in
+// "a", "b" or "c".
+func quoteList(values []string) string {
+	l := make([]string, len(values))
+	for i, v := range values {
+		l[i] = fmt.Sprintf("%q", v)
+	}
+	if len(l) < 2 {
+		return strings.Join(l, "")
+	}
+	return strings.Join(l[:len(l)-1], ", ") + " or " + l[len(l)-1]
+}
+
+func positiveDurationValidator() validator.String {
+	return stringValidator{"must be a positive duration, e.g. \"72h\"", validatePositiveDuration}
+}
+
+func validatePositiveDuration(v string) error {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("%q is not a valid duration: %v", v, err)
+	}
+	if d <= 0 {
+		return fmt.Errorf("%q is not a valid duration: it must be positive", v)
+	}
+	return nil
+}
+
+func validateOwner(v string) error {
+	if !ownerPattern.MatchString(v) {
+		return fmt.Errorf("%q is not a valid GitHub user or organisation name: it must be at most 39 alphanumeric characters, hyphens and underscores, and must begin and end with an alphanumeric character", v)
+	}
+	return nil
+}
+
+func validateRepositoryName(v string) error {
+	if !repositoryNamePattern.MatchString(v) || v == "." || v == ".." {
+		return fmt.Errorf("%q is not a valid GitHub repository name: it must be at most 100 alphanumeric, '.', '-' or '_' characters", v)
+	}
+	if strings.HasSuffix(strings.ToLower(v), ".git") {
+		return fmt.Errorf("%q is not a valid GitHub repository name: omit the \".git\" suffix", v)
+	}
+	return nil
+}
+
+// validateBranch checks the given branch name against the rules of
+// git check-ref-format.
+func validateBranch(v string) error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%q is not a valid branch name: %s", v, reason)
+	}
+	switch {
+	case v == "":
+		return invalid("it is empty")
+	case strings.HasPrefix(v, "refs/"):
+		return invalid("give the branch name without \"refs/heads/\"")
+	case v == "@":
+		return invalid("it may not be \"@\"")
+	case strings.HasPrefix(v, "/") || strings.HasSuffix(v, "/") || strings.Contains(v, "//"):
+		return invalid("it may not begin or end with '/' or contain \"//\"")
+	case strings.HasSuffix(v, "."):
+		return invalid("it may not end with '.'")
+	case strings.Contains(v, "..") || strings.Contains(v, "@{"):
+		return invalid("it may not contain \"..\" or \"@{\"")
+	}
+	for _, r := range v {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(" ~^:?*[\\", r) {
+			return invalid(fmt.Sprintf("it may not contain %q", r))
+		}
+	}
+	for _, c := range strings.Split(v, "/") {
+		if strings.HasPrefix(c, ".") || strings.HasSuffix(c, ".lock") {
+			return invalid("its components may not begin with '.' or end with \".lock\"")
+		}
+	}
+	return nil
+}
+
+// validateFilePath checks that the given path is a relative path to a file,
+// as the paths of tree entries must be.
+func validateFilePath(v string) error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%q is not a valid file path: %s", v, reason)
+	}
+	switch {
+	case v == "":
+		return invalid("it is empty")
+	case strings.HasPrefix(v, "/"):
+		return invalid("it must be relative to the root of the repository, without a leading '/'")
+	case strings.HasSuffix(v, "/"):
+		return invalid("it must be the path to a file, without a trailing '/'")
+	case strings.Contains(v, ":"):
+		// The ids of githubfile_file resources separate the path with ':'.
+		return invalid("it may not contain ':'")
+	case strings.ContainsRune(v, 0):
+		return invalid("it may not contain NUL characters")
+	}
+	for _, c := range strings.Split(v, "/") {
+		switch {
+		case c == "":
+			return invalid("it may not contain empty segments")
+		case c == "." || c == "..":
+			return invalid("it may not contain \".\" or \"..\" segments")
+		case strings.EqualFold(c, ".git"):
+			return invalid("files under \".git\" cannot be managed")
+		}
+	}
+	return nil
+}
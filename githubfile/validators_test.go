@@ -0,0 +1,80 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidators(t *testing.T) {
+	tests := []struct {
+		name     string
+		validate func(string) error
+		valid    []string
+		invalid  []string
+	}{
+		{
+			name:     "owner",
+			validate: validateOwner,
+			valid:    []string{"form3tech-oss", "octocat", "octocat_acme", "a", strings.Repeat("a", 39)},
+			invalid:  []string{"", strings.Repeat("a", 40), "-octocat", "octocat-", "octo cat", "octo.cat", "form3tech-oss/"},
+		},
+		{
+			name:     "repository name",
+			validate: validateRepositoryName,
+			valid:    []string{"terraform-provider-githubfile", ".github", "a_b.c"},
+			invalid:  []string{"", ".", "..", "a/b", "a b", "repo.git"},
+		},
+		{
+			name:     "branch",
+			validate: validateBranch,
+			valid:    []string{"main", "release/1.2", "feature-x", "v1.0"},
+			invalid:  []string{"", "refs/heads/main", "/main", "main/", "a//b", "a..b", "a.", "a.lock", "a/.b", "a b", "a~1", "a:b", "a@{1}", "@"},
+		},
+		{
+			name:     "file path",
+			validate: validateFilePath,
+			valid:    []string{"README.md", ".github/CODEOWNERS", "a/b/c.txt", ".gitignore", "a\\b"},
+			invalid:  []string{"", "/README.md", "docs/", "a//b", "./a", "a/../b", ".git/config", "a/.git/b", "a:b"},
+		},
+		{
+			name:     "positive duration",
+			validate: validatePositiveDuration,
+			valid:    []string{"72h", "1s", "1h30m"},
+			invalid:  []string{"", "0", "0s", "-1h", "3 days"},
+		},
+		{
+			name:     "one of",
+			validate: validateOneOf("public", "private", "internal"),
+			valid:    []string{"public", "private", "internal"},
+			invalid:  []string{"", "Public", "secret"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, v := range tt.valid {
+				if err := tt.validate(v); err != nil {
+					t.Errorf("expected %q to be valid, got: %v", v, err)
+				}
+			}
+			for _, v := range tt.invalid {
+				if err := tt.validate(v); err == nil {
+					t.Errorf("expected %q to be invalid", v)
+				}
+			}
+		})
+	}
+}
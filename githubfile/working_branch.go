@@ -0,0 +1,313 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/form3tech-oss/go-github-utils/pkg/branch"
+	"github.com/form3tech-oss/go-github-utils/pkg/commit"
+	"github.com/google/go-github/v54/github"
+)
+
+// pendingWrite describes a write whose working branch, and possibly pull
+// request, could not be cleaned up after it failed.
+type pendingWrite struct {
+	Branch      string `json:"branch"`
+	PullRequest int    `json:"pull_request,omitempty"`
+}
+
+// workingBranchName returns the name of the working branch from which to
+// open the pull request making the given change to the given file. It is
+// derived from the change so that a write interrupted after creating its
+// branch is picked up by the next attempt at the same change.
+func workingBranchName(prefix string, f *file, op, contents string) string {
+	h := sha256.New()
+	for _, v := range []string{f.repositoryOwner, f.repositoryName, f.branch, f.path, op, contents} {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return prefix + hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// conflictBranchRegexp matches the names of the branches of the pull requests
+// holding unresolved conflicts, which are left open for people to resolve
+// rather than swept as stale working branches.
+var conflictBranchRegexp = regexp.MustCompile(`conflict-[0-9a-f]{16}$`)
+
+// conflictBranchName returns the name of the branch from which to open the
+// pull request holding the given conflicting changes to the given file.
+func conflictBranchName(prefix string, f *file, merged string) string {
+	return workingBranchName(prefix+"conflict-", f, "conflict", merged)
+}
+
+// gitBlobSHA returns the SHA git assigns to a blob with the given contents.
+func gitBlobSHA(contents string) string {
+	h := sha1.New() //nolint:gosec
+	fmt.Fprintf(h, "blob %d\x00", len(contents))
+	h.Write([]byte(contents))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// commitChange commits the given changes to the file's branch through a pull
+// request from the working branch b, on top of the given base tree if any.
+// If a pull request from b is already open, as left by an interrupted
+// attempt at the same change, it is merged instead. If the changes cannot be
+// committed, the working branch is cleaned up, or recorded as the file's
+// pending write if that fails too.
+func commitChange(ctx context.Context, c *providerConfiguration, f *file, b, message string, changes []*github.TreeEntry, baseTree *string) error {
+	gc := c.client(f.repositoryOwner)
+	abandonPendingWrite(ctx, gc, f, b)
+
+	merged, err := resumePullRequest(ctx, gc, f, b, message)
+	if err != nil {
+		f.pendingWrite = &pendingWrite{Branch: b}
+		return err
+	}
+	if merged {
+		f.pendingWrite = nil
+		return nil
+	}
+
+	username, email, err := c.commitIdentity(ctx, f.repositoryOwner)
+	if err != nil {
+		return err
+	}
+	// The working branch and its pull request are pending until merged, so
+	// that a write stopped before it can clean up is recorded as one.
+	f.pendingWrite = &pendingWrite{Branch: b}
+	mc, mw := watchMerges(gc, f.repositoryOwner, f.repositoryName)
+	if err := commit.CreateCommit(ctx, mc, &commit.CommitOptions{
+		RepoOwner:                   f.repositoryOwner,
+		RepoName:                    f.repositoryName,
+		Branch:                      f.branch,
+		CommitMessage:               message,
+		GpgPassphrase:               c.gpgPassphrase,
+		GpgPrivateKey:               c.gpgSecretKey,
+		Username:                    username,
+		Email:                       email,
+		Changes:                     changes,
+		BaseTreeOverride:            baseTree,
+		PullRequestSourceBranchName: b,
+		PullRequestBody:             "",
+		// Merging is only attempted once, as the client's transport retries
+		// it when the base branch was modified meanwhile, and other failures
+		// to merge are diagnosed below rather than retried.
+		MaxRetries: 1,
+	}); err != nil {
+		if isEmptyRepository(err) {
+			if err := createInitialCommit(ctx, c, f, message, changes); err != nil {
+				return err
+			}
+			f.pendingWrite = nil
+			return nil
+		}
+		if err = wrapMergeFailure(err); errors.Is(err, errMergeFailed) {
+			err = diagnoseMergeFailure(ctx, c, f, mw.pullRequest, err)
+		} else {
+			err = fmt.Errorf("failed to create commit: %v", err)
+		}
+		f.pendingWrite = cleanUpPullRequestBranch(ctx, gc, f, b)
+		return err
+	}
+	f.pendingWrite = nil
+	return nil
+}
+
+// openConflictPullRequest opens a pull request from the working branch b
+// writing the given contents, which hold conflict markers, to the file, and
+// leaves it open for them to be resolved. If a pull request from b is already
+// open, as opened by an earlier attempt at the same merge, it is returned
+// instead.
+func openConflictPullRequest(ctx context.Context, c *providerConfiguration, f *file, b, message, contents string) (*github.PullRequest, error) {
+	gc := c.client(f.repositoryOwner)
+	prs, _, err := gc.PullRequests.List(ctx, f.repositoryOwner, f.repositoryName, &github.PullRequestListOptions{
+		Head:  f.repositoryOwner + ":" + b,
+		State: "open",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look for pull requests from branch %q: %v", b, err)
+	}
+	if len(prs) > 0 {
+		return prs[0], nil
+	}
+
+	s, err := branch.GetSHAForBranch(ctx, gc, f.repositoryOwner, f.repositoryName, f.branch)
+	if err != nil {
+		return nil, err
+	}
+	tree, _, err := gc.Git.CreateTree(ctx, f.repositoryOwner, f.repositoryName, s, []*github.TreeEntry{
+		{
+			Content: github.String(contents),
+			Mode:    github.String("100644"),
+			Path:    github.String(f.path),
+			Type:    github.String("blob"),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tree: %v", err)
+	}
+	commit, err := createCommit(ctx, c, f, message, tree.GetSHA(), s)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := gc.Git.CreateRef(ctx, f.repositoryOwner, f.repositoryName, &github.Reference{
+		Ref:    github.String("refs/heads/" + b),
+		Object: &github.GitObject{SHA: commit.SHA},
+	}); err != nil {
+		return nil, fmt.Errorf("failed to create branch %q: %v", b, err)
+	}
+	pr, _, err := gc.PullRequests.Create(ctx, f.repositoryOwner, f.repositoryName, &github.NewPullRequest{
+		Title: github.String(message),
+		Head:  github.String(b),
+		Base:  github.String(f.branch),
+		Body: github.String(fmt.Sprintf("Changes made to `%s` outside of Terraform conflict with the changes Terraform is applying. "+
+			"Resolve the conflicts marked in the file and merge this pull request, then apply again.", f.path)),
+		MaintainerCanModify: github.Bool(false),
+	})
+	if err != nil {
+		cleanUpPullRequestBranch(ctx, gc, f, b)
+		return nil, fmt.Errorf("failed to open pull request: %v", err)
+	}
+	return pr, nil
+}
+
+// createCommit creates a commit of the given tree in the file's repository,
+// with the given parents, authored by the configured identity and signed with
+// the configured GPG key if any.
+func createCommit(ctx context.Context, c *providerConfiguration, f *file, message, tree string, parents ...string) (*github.Commit, error) {
+	username, email, err := c.commitIdentity(ctx, f.repositoryOwner)
+	if err != nil {
+		return nil, err
+	}
+	commit := &github.Commit{
+		Author: &github.CommitAuthor{
+			Date:  &github.Timestamp{Time: time.Now()},
+			Name:  github.String(username),
+			Email: github.String(email),
+		},
+		Message: github.String(message),
+		Tree:    &github.Tree{SHA: github.String(tree)},
+		Parents: []*github.Commit{},
+	}
+	for _, p := range parents {
+		commit.Parents = append(commit.Parents, &github.Commit{SHA: github.String(p)})
+	}
+	if c.gpgSecretKey != "" {
+		k, err := readGPGSigningKey(c.gpgSecretKey, c.gpgPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		commit.SigningKey = k
+	}
+	v, _, err := c.client(f.repositoryOwner).Git.CreateCommit(ctx, f.repositoryOwner, f.repositoryName, commit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create commit: %v", err)
+	}
+	return v, nil
+}
+
+// abandonPendingWrite cleans up the file's pending write, if any, unless its
+// working branch is keep, in which case it is left to be resumed.
+func abandonPendingWrite(ctx context.Context, gc *github.Client, f *file, keep string) {
+	if f.pendingWrite == nil || f.pendingWrite.Branch == keep {
+		return
+	}
+	log.Printf("[INFO] Cleaning up branch %q of %s/%s left by an earlier write of %q", f.pendingWrite.Branch, f.repositoryOwner, f.repositoryName, f.path)
+	// Whatever cannot be cleaned up now is left to githubfile_branch_cleanup.
+	cleanUpPullRequestBranch(ctx, gc, f, f.pendingWrite.Branch)
+	f.pendingWrite = nil
+}
+
+// resumePullRequest merges the pull request from the working branch b left
+// open by an interrupted write, if any, returning whether it did. A branch
+// left without a pull request, or whose pull request cannot be merged, is
+// deleted so that the write can start afresh.
+func resumePullRequest(ctx context.Context, gc *github.Client, f *file, b, message string) (bool, error) {
+	prs, _, err := gc.PullRequests.List(ctx, f.repositoryOwner, f.repositoryName, &github.PullRequestListOptions{
+		Head:  f.repositoryOwner + ":" + b,
+		State: "open",
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to look for pull requests from branch %q: %v", b, err)
+	}
+	if len(prs) > 0 {
+		n := prs[0].GetNumber()
+		log.Printf("[INFO] Resuming pull request #%d of %s/%s left open by an interrupted write of %q", n, f.repositoryOwner, f.repositoryName, f.path)
+		if _, _, err := gc.PullRequests.Merge(ctx, f.repositoryOwner, f.repositoryName, n, message, nil); err == nil {
+			// As for new pull requests, failing to delete the branch is not
+			// critical.
+			_, _ = gc.Git.DeleteRef(ctx, f.repositoryOwner, f.repositoryName, "heads/"+b)
+			return true, nil
+		}
+		log.Printf("[WARN] Failed to merge pull request #%d of %s/%s, replacing it: %v", n, f.repositoryOwner, f.repositoryName, err)
+		if p := cleanUpPullRequestBranch(ctx, gc, f, b); p != nil {
+			return false, fmt.Errorf("failed to clean up pull request #%d from branch %q", n, b)
+		}
+		return false, nil
+	}
+	if r, err := gc.Git.DeleteRef(ctx, f.repositoryOwner, f.repositoryName, "heads/"+b); err != nil && !isMissingRef(r) && !isEmptyRepository(err) {
+		return false, fmt.Errorf("failed to delete branch %q: %v", b, err)
+	}
+	return false, nil
+}
+
+// cleanUpPullRequestBranch closes any pull request left open from the given
+// working branch by a commit that did not complete, and deletes the branch.
+// It runs even if ctx is done, as the expiry of ctx may be what stopped the
+// commit from completing. Failing to clean up is not an error, but what was
+// left behind is returned.
+func cleanUpPullRequestBranch(ctx context.Context, gc *github.Client, f *file, b string) *pendingWrite {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
+	defer cancel()
+
+	var p *pendingWrite
+	prs, _, err := gc.PullRequests.List(ctx, f.repositoryOwner, f.repositoryName, &github.PullRequestListOptions{
+		Head:  f.repositoryOwner + ":" + b,
+		State: "open",
+	})
+	if err != nil {
+		log.Printf("[WARN] Failed to list pull requests from branch %q of %s/%s: %v", b, f.repositoryOwner, f.repositoryName, err)
+		p = &pendingWrite{Branch: b}
+	}
+	for _, pr := range prs {
+		if _, _, err := gc.PullRequests.Edit(ctx, f.repositoryOwner, f.repositoryName, pr.GetNumber(), &github.PullRequest{State: github.String("closed")}); err != nil {
+			log.Printf("[WARN] Failed to close pull request #%d of %s/%s: %v", pr.GetNumber(), f.repositoryOwner, f.repositoryName, err)
+			p = &pendingWrite{Branch: b, PullRequest: pr.GetNumber()}
+		}
+	}
+	if r, err := gc.Git.DeleteRef(ctx, f.repositoryOwner, f.repositoryName, "heads/"+b); err != nil && !isMissingRef(r) {
+		log.Printf("[WARN] Failed to delete branch %q of %s/%s: %v", b, f.repositoryOwner, f.repositoryName, err)
+		if p == nil {
+			p = &pendingWrite{Branch: b}
+		}
+	}
+	return p
+}
+
+// isMissingRef returns whether the given response to deleting a ref says
+// that the ref does not exist.
+func isMissingRef(r *github.Response) bool {
+	return r != nil && (r.StatusCode == http.StatusUnprocessableEntity || r.StatusCode == http.StatusNotFound)
+}
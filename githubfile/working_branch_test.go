@@ -0,0 +1,136 @@
+// Copyright 2019 Form3 Financial Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package githubfile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGitBlobSHA(t *testing.T) {
+	// As computed by `git hash-object`.
+	if v, expected := gitBlobSHA("hello\n"), "ce013625030ba8dba906f756967f9e9ca394464a"; v != expected {
+		t.Errorf("expected %q, got %q", expected, v)
+	}
+}
+
+func TestWorkingBranchName(t *testing.T) {
+	f := &file{repositoryOwner: "o", repositoryName: "r", branch: "main", path: "a.txt"}
+	b := workingBranchName("tf-", f, "write", "a")
+	if !strings.HasPrefix(b, "tf-") {
+		t.Errorf("expected %q to start with the prefix", b)
+	}
+	if v := workingBranchName("tf-", f, "write", "a"); v != b {
+		t.Errorf("expected the same change to give the same branch, got %q and %q", b, v)
+	}
+	if v := workingBranchName("tf-", f, "write", "b"); v == b {
+		t.Errorf("expected different contents to give different branches, got %q", v)
+	}
+	if v := workingBranchName("tf-", f, "delete", "a"); v == b {
+		t.Errorf("expected a deletion to give a different branch, got %q", v)
+	}
+}
+
+func TestConflictBranchName(t *testing.T) {
+	f := &file{repositoryOwner: "o", repositoryName: "r", branch: "main", path: "a.txt"}
+	if v := conflictBranchName("tf-", f, "a"); !strings.HasPrefix(v, "tf-conflict-") || !conflictBranchRegexp.MatchString(v) {
+		t.Errorf("expected %q to be recognised as a conflict branch", v)
+	}
+	if v := workingBranchName("tf-", f, "write", "a"); conflictBranchRegexp.MatchString(v) {
+		t.Errorf("expected %q not to be recognised as a conflict branch", v)
+	}
+}
+
+func TestResumePullRequest(t *testing.T) {
+	var merged, deleted bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo/pulls", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[{"number":5,"state":"open"}]`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/pulls/5/merge", func(w http.ResponseWriter, r *http.Request) {
+		merged = r.Method == http.MethodPut
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"merged":true}`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/git/refs/heads/work", func(w http.ResponseWriter, r *http.Request) {
+		deleted = r.Method == http.MethodDelete
+		w.WriteHeader(http.StatusNoContent)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	f := &file{repositoryOwner: "test-owner", repositoryName: "test-repo", branch: "main", path: "a.txt"}
+	ok, err := resumePullRequest(context.Background(), newMockGitHubClient(server), f, "work", "Update \"a.txt\".")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if !ok || !merged {
+		t.Error("expected the open pull request to be merged")
+	}
+	if !deleted {
+		t.Error("expected the working branch to be deleted")
+	}
+}
+
+func TestCreateOrUpdateFile_AlreadyWritten(t *testing.T) {
+	var cleanedUp bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test-owner/test-repo/contents/a.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"type":"file","path":"a.txt","sha":%q,"encoding":"base64","content":"aGVsbG8K"}`, gitBlobSHA("hello\n"))
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/pulls", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[]`)
+	})
+	mux.HandleFunc("/repos/test-owner/test-repo/git/refs/heads/old", func(w http.ResponseWriter, r *http.Request) {
+		cleanedUp = r.Method == http.MethodDelete
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &providerConfiguration{
+		branchPrefix: "tf-",
+		githubClient: newMockGitHubClient(server),
+	}
+	f := &file{
+		repositoryOwner: "test-owner",
+		repositoryName:  "test-repo",
+		branch:          "main",
+		path:            "a.txt",
+		contents:        "hello\n",
+		pendingWrite:    &pendingWrite{Branch: "old"},
+	}
+
+	if err := createOrUpdateFile(context.Background(), config, f, "Update %q."); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if !cleanedUp {
+		t.Error("expected the pending write's branch to be cleaned up")
+	}
+	if f.pendingWrite != nil {
+		t.Errorf("expected no pending write, got %+v", f.pendingWrite)
+	}
+}